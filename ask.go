@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"askgo/attach"
+	"askgo/llm"
+	"askgo/repl"
+)
+
+// errNothingToAsk is returned by ask without a prompt or piped input.
+var errNothingToAsk = errors.New("nothing to ask: pass a question or pipe input to askgo ask")
+
+// stdinWait is how long ask waits for piped input to start when the
+// question came as arguments. Some CI runners and ssh sessions leave stdin
+// a pipe that is never written to or closed, which would otherwise hang
+// it; "-" waits for slow input instead.
+const stdinWait = time.Second
+
+// runAsk sends a single prompt and prints only the answer, for use in shell
+// pipelines, Makefiles and git hooks. Input piped to stdin is sent along
+// with the question, see readInput.
+func runAsk(cfg llm.Config, args []string) error {
+	fs := flag.NewFlagSet("ask", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: askgo ask [flags] "question"
+       command | askgo ask [flags] ["question"] [-]`)
+		fs.PrintDefaults()
+	}
+	providerFlag := fs.String("provider", "", "Backend to use: groq, openai, ollama or anthropic")
+	modelFlag := fs.String("model", "", "Model to use instead of the provider's default")
+	personaFlag := fs.String("persona", "", "Persona to answer as (see askgo personas)")
+	systemFlag := fs.String("system", "", "System prompt, replacing the persona's")
+	var params llm.Params
+	paramFlags(fs, &params)
+	var attachments []string
+	attachFlags(fs, &attachments)
+	fs.Parse(args)
+
+	// A lone "-" asks for stdin whatever it is connected to
+	var words []string
+	readStdin := false
+	for _, arg := range fs.Args() {
+		if arg == "-" {
+			readStdin = true
+		} else {
+			words = append(words, arg)
+		}
+	}
+	wait := stdinWait
+	if len(words) == 0 || readStdin {
+		wait = 0
+	}
+	input, err := readInput(os.Stdin, readStdin, wait)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	opts := llm.Options{Provider: *providerFlag, Model: *modelFlag, Params: params}
+	system := *systemFlag
+	if *personaFlag != "" {
+		p, err := loadPersona(*personaFlag)
+		if err != nil {
+			return err
+		}
+		opts = p.Options(opts)
+		if system == "" {
+			system = p.System
+		}
+	}
+	if err := cfg.Validate(opts.Provider); err != nil {
+		return err
+	}
+	client := llm.NewClient(cfg)
+
+	question, err := attachFiles(client, opts, strings.Join(words, " "), attachments, os.Stderr)
+	if err != nil {
+		return err
+	}
+	messages, err := askMessages(client, opts, system, question, input, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return ask(client, messages, opts, os.Stdout)
+}
+
+// readInput returns what is piped or redirected to stdin; a terminal is
+// read only when always is set. With a wait, a pipe is read only if input
+// starts arriving within it, and is left alone otherwise.
+func readInput(stdin *os.File, always bool, wait time.Duration) (string, error) {
+	stat, err := stdin.Stat()
+	if err != nil || !always && stat.Mode()&os.ModeCharDevice != 0 {
+		return "", nil
+	}
+	if always || wait <= 0 || stat.Mode().IsRegular() {
+		data, err := io.ReadAll(stdin)
+		return string(data), err
+	}
+
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	first := make(chan chunk, 1)
+	go func() {
+		buf := make([]byte, 32*1024)
+		n, err := stdin.Read(buf)
+		first <- chunk{buf[:n], err}
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case c := <-first:
+		if c.err == io.EOF {
+			return string(c.data), nil
+		} else if c.err != nil {
+			return "", c.err
+		}
+		rest, err := io.ReadAll(stdin)
+		return string(c.data) + string(rest), err
+	case <-timer.C:
+		return "", nil
+	}
+}
+
+// attachFiles appends the files of paths and those question references as
+// @path to question, noting files left out on notes.
+func attachFiles(client *llm.Client, opts llm.Options, question string, paths []string, notes io.Writer) (string, error) {
+	paths = append(paths, attach.References(question)...)
+	if len(paths) == 0 {
+		return question, nil
+	}
+	provider := opts.Provider
+	if provider == "" {
+		provider = client.Provider()
+	}
+	model := opts.Model
+	if model == "" {
+		model = client.ProviderModel(provider)
+	}
+
+	set := attach.NewSet(attach.Budget(client.ContextWindow(provider, model)))
+	for _, path := range paths {
+		if err := set.Add(path); err != nil {
+			return "", err
+		}
+	}
+	for _, skipped := range set.Skipped {
+		fmt.Fprintf(notes, "askgo: skipped %s: %s\n", skipped.Path, skipped.Reason)
+	}
+	return set.Prompt(question), nil
+}
+
+// askMessages builds the request of a one-shot question. Input too long
+// for the model's context window keeps its last lines, which is where logs
+// and compiler output usually hold the interesting part; a note about the
+// cut goes to notes.
+func askMessages(client *llm.Client, opts llm.Options, system, question, input string, notes io.Writer) ([]llm.Message, error) {
+	question = strings.TrimSpace(question)
+	input = strings.TrimRight(input, "\n")
+	if question == "" && strings.TrimSpace(input) == "" {
+		return nil, errNothingToAsk
+	}
+
+	provider := opts.Provider
+	if provider == "" {
+		provider = client.Provider()
+	}
+	model := opts.Model
+	if model == "" {
+		model = client.ProviderModel(provider)
+	}
+	// Leave a quarter of the window for the reply and the prompts
+	budget := client.ContextWindow(provider, model)*3/4 - llm.CountTokens(system) - llm.CountTokens(question)
+	if llm.CountTokens(input) > budget {
+		lines := strings.Split(input, "\n")
+		kept := len(lines)
+		for tokens := 0; kept > 0; kept-- {
+			if tokens += llm.CountTokens(lines[kept-1]) + 1; tokens > budget {
+				break
+			}
+		}
+		fmt.Fprintf(notes, "askgo: input too long for %s, sending its last %d of %d lines\n", model, len(lines)-kept, len(lines))
+		input = fmt.Sprintf("[%d earlier lines omitted]\n%s", kept, strings.Join(lines[kept:], "\n"))
+	}
+
+	content := question
+	switch {
+	case question == "":
+		content = input
+	case input != "":
+		content = question + "\n\n" + input
+	}
+
+	var messages []llm.Message
+	if system != "" {
+		messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: system})
+	}
+	return append(messages, llm.Message{Role: llm.RoleUser, Content: content}), nil
+}
+
+// ask streams the answer to messages to out, ending it with a newline.
+func ask(client *llm.Client, messages []llm.Message, opts llm.Options, out io.Writer) error {
+	var last string
+	_, err := client.ChatStream(context.Background(), messages, opts, func(delta string) error {
+		if delta != "" {
+			last = delta
+		}
+		_, err := io.WriteString(out, delta)
+		return err
+	})
+	if last != "" && !strings.HasSuffix(last, "\n") {
+		fmt.Fprintln(out)
+	}
+	if err != nil {
+		return errors.New(repl.ErrorText(err))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadInput(t *testing.T) {
+	const wait = 50 * time.Millisecond
+
+	t.Run("pipe left open", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		defer r.Close()
+
+		done := make(chan string)
+		go func() {
+			input, _ := readInput(r, false, wait)
+			done <- input
+		}()
+		select {
+		case input := <-done:
+			if input != "" {
+				t.Errorf("input = %q, want none", input)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("hung on a pipe nobody writes to")
+		}
+	})
+
+	t.Run("pipe with data", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		go func() {
+			w.WriteString("first\n")
+			time.Sleep(2 * wait)
+			w.WriteString("second\n")
+			w.Close()
+		}()
+		input, err := readInput(r, false, wait)
+		if err != nil || input != "first\nsecond\n" {
+			t.Errorf("input = %q, %v; want all of it once it started", input, err)
+		}
+	})
+
+	t.Run("slow pipe with dash", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		go func() {
+			time.Sleep(2 * wait)
+			w.WriteString("late")
+			w.Close()
+		}()
+		input, err := readInput(r, true, wait)
+		if err != nil || input != "late" {
+			t.Errorf("input = %q, %v; want the late input", input, err)
+		}
+	})
+
+	t.Run("redirected file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "input.txt")
+		if err := os.WriteFile(path, []byte("from a file"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		input, err := readInput(f, false, wait)
+		if err != nil || input != "from a file" {
+			t.Errorf("input = %q, %v", input, err)
+		}
+	})
+}
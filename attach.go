@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// attachFlags registers -file and -dir on fs, each of which may be given
+// several times, collecting their paths in paths.
+func attachFlags(fs *flag.FlagSet, paths *[]string) {
+	fs.Func("file", "Attach a file to the prompt (repeatable)", func(path string) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory; use -dir", path)
+		}
+		*paths = append(*paths, path)
+		return nil
+	})
+	fs.Func("dir", "Attach the files of a directory, respecting .gitignore (repeatable)", func(path string) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory; use -file", path)
+		}
+		*paths = append(*paths, path)
+		return nil
+	})
+}
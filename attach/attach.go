@@ -0,0 +1,214 @@
+// Package attach inlines files into prompts. Files come from paths given on
+// the command line, @path references in the prompt itself or uploads;
+// directories are walked respecting .gitignore, binary files are skipped
+// and the whole set is kept within a token budget.
+package attach
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"askgo/llm"
+)
+
+// File is a file attached to a prompt.
+type File struct {
+	Path    string `json:"path"`
+	Content string `json:"-"`
+	Tokens  int    `json:"tokens"`
+}
+
+// Skipped is a file left out of the prompt.
+type Skipped struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Set collects the files attached to one prompt.
+type Set struct {
+	// Budget is the most tokens the files may take; 0 is unlimited.
+	Budget  int
+	Files   []File
+	Skipped []Skipped
+
+	tokens int
+	seen   map[string]bool
+}
+
+// NewSet returns an empty set limited to budget tokens.
+func NewSet(budget int) *Set {
+	return &Set{Budget: budget, seen: make(map[string]bool)}
+}
+
+// Budget returns the tokens attachments may take in a model's context
+// window: half of it, leaving room for the conversation and the reply.
+func Budget(window int) int {
+	return window / 2
+}
+
+// Tokens returns the tokens the attached files take in the prompt.
+func (s *Set) Tokens() int {
+	return s.tokens
+}
+
+// Add attaches the file or, recursively, the directory at path.
+func (s *Set) Add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return s.AddDir(path)
+	}
+	return s.AddFile(path)
+}
+
+// AddFile attaches the file at path. It is attached even if .gitignore
+// ignores it, since it was asked for by name.
+func (s *Set) AddFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	path = filepath.Clean(path)
+	if s.seen[path] {
+		return nil
+	}
+	if s.Budget > 0 && info.Size() > int64(s.Budget-s.tokens)*16 {
+		// Far too big for what is left; do not bother reading it
+		s.seen[path] = true
+		s.skip(path, s.budgetReason())
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s.AddData(path, data)
+	return nil
+}
+
+// AddDir attaches the files below dir, leaving out those ignored by the
+// .gitignore files of dir, its subdirectories and its parents up to the
+// root of the git repository.
+func (s *Set) AddDir(dir string) error {
+	ig, err := newIgnorer(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			s.skip(path, err.Error())
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || path != dir && ig.ignored(path, true) {
+				return filepath.SkipDir
+			}
+			ig.load(path)
+			return nil
+		}
+		if !d.Type().IsRegular() || ig.ignored(path, false) {
+			return nil
+		}
+		if err := s.AddFile(path); err != nil {
+			s.skip(path, err.Error())
+		}
+		return nil
+	})
+}
+
+// AddData attaches data read from a file called name, such as an upload.
+// It reports whether the file was attached rather than skipped.
+func (s *Set) AddData(name string, data []byte) bool {
+	if s.seen[name] {
+		return false
+	}
+	s.seen[name] = true
+
+	if isBinary(data) {
+		s.skip(name, "binary file")
+		return false
+	}
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	tokens := llm.CountTokens(fileBlock(name, content))
+	if s.Budget > 0 && s.tokens+tokens > s.Budget {
+		s.skip(name, s.budgetReason())
+		return false
+	}
+	s.tokens += tokens
+	s.Files = append(s.Files, File{Path: name, Content: content, Tokens: tokens})
+	return true
+}
+
+func (s *Set) skip(path, reason string) {
+	s.Skipped = append(s.Skipped, Skipped{Path: path, Reason: reason})
+}
+
+func (s *Set) budgetReason() string {
+	return fmt.Sprintf("does not fit the budget of %d tokens for attachments", s.Budget)
+}
+
+// Prompt returns text followed by the attached files, each between
+// <file path="..."> and </file> lines.
+func (s *Set) Prompt(text string) string {
+	if len(s.Files) == 0 {
+		return text
+	}
+	blocks := make([]string, 0, len(s.Files)+1)
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		blocks = append(blocks, text)
+	}
+	for _, f := range s.Files {
+		blocks = append(blocks, fileBlock(f.Path, f.Content))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+func fileBlock(path, content string) string {
+	return fmt.Sprintf("<file path=%q>\n%s\n</file>", filepath.ToSlash(path), strings.TrimRight(content, "\n"))
+}
+
+// isBinary reports whether data looks like a binary file: a NUL byte near
+// the start, or text that is not UTF-8.
+func isBinary(data []byte) bool {
+	head := data[:min(len(data), 8000)]
+	return bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(data)
+}
+
+// referencePattern matches @path references at the start of a word.
+var referencePattern = regexp.MustCompile(`(^|\s)@(\S+)`)
+
+// References returns the paths referenced as @path in text that exist.
+// Trailing punctuation is not part of the path unless the file has it, so
+// "explain @main.go." refers to main.go.
+func References(text string) []string {
+	var paths []string
+	for _, match := range referencePattern.FindAllStringSubmatch(text, -1) {
+		path := match[2]
+		for path != "" {
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+				break
+			}
+			trimmed := strings.TrimRight(path, ".,;:!?)'\"`")
+			if trimmed == path {
+				break
+			}
+			path = trimmed
+		}
+	}
+	return paths
+}
@@ -0,0 +1,159 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"askgo/llm"
+)
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"empty", nil, false},
+		{"text", []byte("hello\nworld\n"), false},
+		{"utf-8", []byte("grüße, 世界 🎉"), false},
+		{"nul byte", []byte("PNG\x00\x01"), true},
+		{"latin-1", []byte("gr\xfc\xdfe"), true},
+		{"nul after the head", append([]byte(strings.Repeat("a", 9000)), 0), false},
+		{"invalid utf-8 after the head", append([]byte(strings.Repeat("a", 9000)), 0xff), true},
+	}
+	for _, tt := range tests {
+		if got := isBinary(tt.data); got != tt.want {
+			t.Errorf("%s: isBinary = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetBudget(t *testing.T) {
+	small := strings.Repeat("word ", 20)
+	cost := llm.CountTokens(fileBlock("a.txt", small))
+
+	set := NewSet(2*cost + cost/2)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if name != "c.txt" && !set.AddData(name, []byte(small)) {
+			t.Fatalf("AddData(%s) skipped a file within the budget", name)
+		}
+	}
+	if set.AddData("c.txt", []byte(small)) {
+		t.Error("AddData attached a file over the budget")
+	}
+	if set.Tokens() != 2*cost {
+		t.Errorf("Tokens = %d, want %d", set.Tokens(), 2*cost)
+	}
+	if len(set.Skipped) != 1 || set.Skipped[0].Path != "c.txt" || !strings.Contains(set.Skipped[0].Reason, "budget") {
+		t.Errorf("Skipped = %+v, want c.txt over the budget", set.Skipped)
+	}
+
+	// A smaller file still fits what is left
+	if !set.AddData("tiny.txt", []byte("x")) {
+		t.Error("AddData skipped a file fitting the rest of the budget")
+	}
+
+	// Without a budget everything fits
+	unlimited := NewSet(0)
+	if !unlimited.AddData("big.txt", []byte(strings.Repeat(small, 1000))) {
+		t.Error("AddData skipped a file without a budget")
+	}
+}
+
+func TestAddFileTooBig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.txt")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 4096)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := NewSet(100)
+	if err := set.AddFile(path); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if len(set.Files) != 0 || len(set.Skipped) != 1 {
+		t.Errorf("Files = %v, Skipped = %v, want the file skipped", set.Files, set.Skipped)
+	}
+	// Asking again does not report it twice
+	set.AddFile(path)
+	if len(set.Skipped) != 1 {
+		t.Errorf("Skipped = %v after adding the file twice", set.Skipped)
+	}
+}
+
+func TestAddDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := NewSet(0)
+	for _, p := range []string{path, filepath.Join(dir, ".", "main.go"), dir} {
+		if err := set.Add(p); err != nil {
+			t.Fatalf("Add(%s): %v", p, err)
+		}
+	}
+	if len(set.Files) != 1 {
+		t.Errorf("attached %d copies of the file, want 1", len(set.Files))
+	}
+	if set.AddData("upload.bin", []byte{0, 1, 2}) || len(set.Skipped) != 1 || set.Skipped[0].Reason != "binary file" {
+		t.Errorf("Skipped = %v, want the binary upload", set.Skipped)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	set := NewSet(0)
+	set.AddData(filepath.Join("src", "a.go"), []byte("package a\r\n\n"))
+	set.AddData("b.md", []byte("# B"))
+
+	want := "Explain these\n\n" +
+		"<file path=\"src/a.go\">\npackage a\n</file>\n\n" +
+		"<file path=\"b.md\">\n# B\n</file>"
+	if got := set.Prompt("Explain these\n"); got != want {
+		t.Errorf("Prompt =\n%s\nwant\n%s", got, want)
+	}
+	if got := NewSet(0).Prompt("unchanged\n"); got != "unchanged\n" {
+		t.Errorf("Prompt without files = %q", got)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go": "x",
+		"v1.":     "a file whose name ends in a dot",
+		"sub/":    "",
+	})
+	p := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"explain @" + p("main.go"), []string{p("main.go")}},
+		{"@" + p("main.go") + " first", []string{p("main.go")}},
+		{"what does @" + p("main.go") + ". do?", []string{p("main.go")}},
+		{"look (see @" + p("main.go") + ")", []string{p("main.go")}},
+		{"in '@" + p("main.go") + "'", nil},
+		{"quoted @" + p("main.go") + "\",", []string{p("main.go")}},
+		{"and @" + p("main.go") + "?!", []string{p("main.go")}},
+		{"keeps @" + p("v1."), []string{p("v1.")}},
+		{"directory @" + p("sub") + ",", []string{p("sub")}},
+		{"line one\n@" + p("main.go"), []string{p("main.go")}},
+		{"both @" + p("main.go") + " and @" + p("sub"), []string{p("main.go"), p("sub")}},
+		{"missing @" + p("nope.go"), nil},
+		{"mail me@" + p("main.go"), nil},
+		{"just @", nil},
+	}
+	for _, tt := range tests {
+		if got := References(tt.text); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("References(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	if got := Budget(8192); got != 4096 {
+		t.Errorf("Budget(8192) = %d, want 4096", got)
+	}
+}
@@ -0,0 +1,166 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	// base is the directory of the .gitignore file.
+	base    string
+	pattern *regexp.Regexp
+	// anchored patterns contain a slash and match the path relative to
+	// base; the others match the name at any depth.
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// ignorer decides which files of a directory tree git ignores. It covers
+// the patterns of .gitignore files, not git's global excludes.
+type ignorer struct {
+	rules []ignoreRule
+}
+
+// newIgnorer returns an ignorer for walking dir, holding the rules of the
+// .gitignore files above it up to the root of its git repository.
+func newIgnorer(dir string) (*ignorer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	ig := &ignorer{}
+
+	// Find the repository root, collecting the directories on the way
+	var parents []string
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		parents = append(parents, d)
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+		if filepath.Dir(d) == d {
+			// Not in a repository: only dir's own files apply
+			parents = nil
+			break
+		}
+	}
+	if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil {
+		parents = nil
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		ig.load(parents[i])
+	}
+	return ig, nil
+}
+
+// load adds the rules of dir's .gitignore, if it has one. Rules of deeper
+// directories are loaded later and so take precedence.
+func (ig *ignorer) load(dir string) {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " ")
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(globRegexp(line))
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		ig.rules = append(ig.rules, rule)
+	}
+}
+
+// ignored reports whether path is ignored; the last matching rule wins.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		subject := filepath.ToSlash(rel)
+		if !rule.anchored {
+			subject = filepath.Base(rel)
+		}
+		if rule.pattern.MatchString(subject) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globRegexp translates a gitignore glob into an anchored regular
+// expression: * and ? stop at slashes, ** crosses them.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			b.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
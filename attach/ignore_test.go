@@ -0,0 +1,176 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.log", "debug.log", true},
+		{"*.log", "debug.log.old", false},
+		{"*.log", "logs/debug.log", false},
+		{"debug?.log", "debug1.log", true},
+		{"debug?.log", "debug/.log", false},
+		{"debug[0-9].log", "debug7.log", true},
+		{"debug[!0-9].log", "debug7.log", false},
+		{"debug[!0-9].log", "debugx.log", true},
+		{"[unclosed", "[unclosed", true},
+		{`\#hash`, "#hash", true},
+		{`\!bang`, "!bang", true},
+		{"a.b", "axb", false},
+		{"**/cache", "cache", true},
+		{"**/cache", "a/b/cache", true},
+		{"docs/**", "docs/a/b.md", true},
+		{"docs/**", "docs", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/c", false},
+		{"a/*/b", "a/x/y/b", false},
+		{"**.tmp", "x/y.tmp", true},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(globRegexp(tt.glob))
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v (regexp %s)", tt.glob, tt.path, got, tt.want, re)
+		}
+	}
+}
+
+// writeTree creates the files of tree below dir; names ending in a slash
+// are directories.
+func writeTree(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// attached returns the files AddDir attaches from dir, relative to dir.
+func attached(t *testing.T, dir string) []string {
+	t.Helper()
+	set := NewSet(0)
+	if err := set.AddDir(dir); err != nil {
+		t.Fatalf("AddDir: %v", err)
+	}
+	var paths []string
+	for _, f := range set.Files {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestIgnorer(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore": "# comment\n" +
+			"\n" +
+			"*.log\n" +
+			"!keep.log\n" +
+			"build/\n" +
+			"/top.txt\n" +
+			"docs/**/*.tmp\n" +
+			"**/cache\n" +
+			"trailing.txt   \r\n",
+		"main.go":                 "package main",
+		"debug.log":               "x",
+		"keep.log":                "x",
+		"top.txt":                 "x",
+		"sub/top.txt":             "x",
+		"build/out.bin":           "x",
+		"sub/build":               "a file, not a directory",
+		"docs/a/b/draft.tmp":      "x",
+		"docs/readme.md":          "x",
+		"other/draft.tmp":         "x",
+		"cache/data":              "x",
+		"sub/deep/cache/data":     "x",
+		"trailing.txt":            "x",
+		"sub/.gitignore":          "!*.log\nlocal.txt\n",
+		"sub/verbose.log":         "x",
+		"sub/local.txt":           "x",
+		"local.txt":               "x",
+		".git/HEAD":               "ref",
+		"sub/deep/notes.md":       "x",
+		"sub/deep/reincluded.log": "x",
+	})
+
+	want := []string{
+		".gitignore",
+		"docs/readme.md",
+		"keep.log",
+		"local.txt",
+		"main.go",
+		"other/draft.tmp",
+		"sub/.gitignore",
+		"sub/build",
+		"sub/deep/notes.md",
+		"sub/deep/reincluded.log",
+		"sub/top.txt",
+		"sub/verbose.log",
+	}
+	if got := attached(t, dir); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("attached\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// The .gitignore files above a walked directory apply up to the root of
+// its repository, and those outside it do not.
+func TestIgnorerParents(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":             "*.secret\n",
+		"repo/.git/":             "",
+		"repo/.gitignore":        "*.log\n/pkg/gen.go\n",
+		"repo/pkg/.gitignore":    "!important.log\n",
+		"repo/pkg/a.go":          "x",
+		"repo/pkg/gen.go":        "x",
+		"repo/pkg/a.log":         "x",
+		"repo/pkg/a.secret":      "x",
+		"repo/pkg/important.log": "x",
+	})
+
+	want := []string{".gitignore", "a.go", "a.secret", "important.log"}
+	if got := attached(t, filepath.Join(dir, "repo", "pkg")); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("attached %v, want %v", got, want)
+	}
+}
+
+// Files asked for by name are attached even when ignored.
+func TestIgnoredFileByName(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore": "*.log\n",
+		"debug.log":  "x",
+	})
+	set := NewSet(0)
+	if err := set.Add(filepath.Join(dir, "debug.log")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if len(set.Files) != 1 {
+		t.Errorf("attached %d files, want the ignored one asked for", len(set.Files))
+	}
+}
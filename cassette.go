@@ -0,0 +1,39 @@
+//go:build cassette
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"askgo/llm/llmtest"
+)
+
+// Binaries built with -tags cassette record upstream exchanges to the file
+// in ASKGO_CASSETTE, or replay them with ASKGO_CASSETTE_MODE=replay, e.g.
+// to capture test fixtures with any front-end.
+func init() {
+	upstreamTransport = cassetteTransport
+}
+
+// cassetteTransport returns the transport ASKGO_CASSETTE and
+// ASKGO_CASSETTE_MODE ask for, or nil when no cassette is set.
+func cassetteTransport() (http.RoundTripper, error) {
+	path := os.Getenv("ASKGO_CASSETTE")
+	if path == "" {
+		return nil, nil
+	}
+	switch mode := os.Getenv("ASKGO_CASSETTE_MODE"); mode {
+	case "", "record":
+		return llmtest.NewRecorder(path, nil), nil
+	case "replay":
+		replayer, err := llmtest.NewReplayer(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening cassette: %w", err)
+		}
+		return replayer, nil
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+}
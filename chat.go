@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"askgo/llm"
+	"askgo/repl"
+)
+
+// runChat runs the interactive terminal chat loop.
+func runChat(cfg llm.Config, save bool, args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	saveFlag := fs.Bool("save", save, "Save the conversation to a file")
+	providerFlag := fs.String("provider", "", "Backend to use: groq, openai, ollama or anthropic")
+	modelFlag := fs.String("model", "", "Model to use instead of the provider's default")
+	personaFlag := fs.String("persona", "", "Persona to chat with (see askgo personas)")
+	rawFlag := fs.Bool("raw", false, "Print answers as plain text instead of rendering their markdown")
+	var params llm.Params
+	paramFlags(fs, &params)
+	var attachments []string
+	attachFlags(fs, &attachments)
+	fs.Parse(args)
+
+	opts := llm.Options{Provider: *providerFlag, Model: *modelFlag, Params: params}
+
+	// Flags take precedence over the persona's defaults
+	var system string
+	if *personaFlag != "" {
+		p, err := loadPersona(*personaFlag)
+		if err != nil {
+			return err
+		}
+		opts = p.Options(opts)
+		system = p.System
+	}
+	if err := cfg.Validate(opts.Provider); err != nil {
+		return err
+	}
+
+	s := repl.NewSession(llm.NewClient(cfg), opts, system)
+	s.Transcript = *saveFlag
+	s.Raw = *rawFlag
+	s.Attach = attachments
+	return s.Run(os.Stdin)
+}
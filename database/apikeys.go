@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyPrefix starts every askGPT API key so they are easy to recognise.
+const apiKeyPrefix = "askgo-"
+
+// ErrAPIKeyNotFound is returned for unknown or revoked API keys.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey authenticates programmatic access on behalf of a user. Only a hash
+// of the key is stored; the key itself is shown once when it is created.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Hint       string             `bson:"hint"`
+	Hash       string             `bson:"hash"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	LastUsedAt time.Time          `bson:"last_used_at,omitempty"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey issues a new API key for userID and returns the plaintext key
+// alongside its stored record.
+func CreateAPIKey(userID primitive.ObjectID, name string) (string, *APIKey, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+
+	apiKey := APIKey{
+		UserID:    userID,
+		Name:      name,
+		Hint:      key[:len(apiKeyPrefix)+4] + "..." + key[len(key)-4:],
+		Hash:      hashAPIKey(key),
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := apiKeyCollection.InsertOne(ctx, apiKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+	return key, &apiKey, nil
+}
+
+// ListAPIKeys returns the API keys of userID, newest first.
+func ListAPIKeys(userID primitive.ObjectID) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := apiKeyCollection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key of userID.
+func DeleteAPIKey(userID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := apiKeyCollection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the user owning key and records its use.
+func AuthenticateAPIKey(key string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var apiKey APIKey
+	err := apiKeyCollection.FindOneAndUpdate(ctx,
+		bson.M{"hash": hashAPIKey(key)},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}},
+	).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return GetUserByID(apiKey.UserID)
+}
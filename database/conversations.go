@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"askgo/llm"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist or
+// belongs to another user.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// Conversation is one named chat of a user, stored in the chats collection.
+// Provider and Model select the backend answering in it; empty means the
+// server default. Params are its sampling parameters and Persona names the
+// persona whose system prompt opens every request. Summary condenses the
+// oldest Messages, which are still kept in full, for requests.
+type Conversation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Title     string             `bson:"title"`
+	Provider  string             `bson:"provider,omitempty"`
+	Model     string             `bson:"model,omitempty"`
+	Params    llm.Params         `bson:"params,omitempty"`
+	Persona   string             `bson:"persona,omitempty"`
+	Messages  Messages           `bson:"messages"`
+	Summary   llm.Summary        `bson:"summary,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// CreateConversation stores a new, empty conversation for userID.
+func CreateConversation(userID primitive.ObjectID, title string) (*Conversation, error) {
+	return InsertConversation(Conversation{UserID: userID, Title: title})
+}
+
+// InsertConversation stores conversation, with its settings and messages,
+// as a new document in one write, so that a failure leaves nothing behind.
+// Its ID and timestamps are filled in.
+func InsertConversation(conversation Conversation) (*Conversation, error) {
+	now := time.Now()
+	conversation.ID = primitive.NilObjectID
+	conversation.CreatedAt, conversation.UpdatedAt = now, now
+	if conversation.Messages == nil {
+		conversation.Messages = Messages{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := chatCollection.InsertOne(ctx, conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation.ID = result.InsertedID.(primitive.ObjectID)
+	return &conversation, nil
+}
+
+// ListConversations returns a page of userID's conversations, most recently
+// updated first. Messages are not loaded.
+func ListConversations(userID primitive.ObjectID, offset, limit int64) ([]Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit).
+		SetProjection(bson.M{"messages": 0})
+	cursor, err := chatCollection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := []Conversation{}
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// GetConversation loads a conversation of userID including its messages.
+func GetConversation(userID, id primitive.ObjectID) (*Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conversation Conversation
+	err := chatCollection.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrConversationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &conversation, nil
+}
+
+// SaveConversationMessages replaces the messages of a conversation.
+func SaveConversationMessages(userID, id primitive.ObjectID, messages []llm.Message) error {
+	return updateConversation(userID, id, bson.M{"messages": messages})
+}
+
+// SaveConversationSummary replaces the running summary of a conversation.
+func SaveConversationSummary(userID, id primitive.ObjectID, summary llm.Summary) error {
+	return updateConversation(userID, id, bson.M{"summary": summary})
+}
+
+// RenameConversation changes the title of a conversation.
+func RenameConversation(userID, id primitive.ObjectID, title string) error {
+	return updateConversation(userID, id, bson.M{"title": title})
+}
+
+// SetConversationModel selects the provider and model answering in a
+// conversation. Empty values reset it to the server default.
+func SetConversationModel(userID, id primitive.ObjectID, provider, model string) error {
+	return updateConversation(userID, id, bson.M{"provider": provider, "model": model})
+}
+
+// SetConversationParams replaces the sampling parameters of a
+// conversation.
+func SetConversationParams(userID, id primitive.ObjectID, params llm.Params) error {
+	return updateConversation(userID, id, bson.M{"params": params})
+}
+
+// SetConversationPersona selects the persona of a conversation; an empty
+// name removes it.
+func SetConversationPersona(userID, id primitive.ObjectID, name string) error {
+	return updateConversation(userID, id, bson.M{"persona": name})
+}
+
+// DeleteConversation removes a conversation and its messages.
+func DeleteConversation(userID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := chatCollection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+func updateConversation(userID, id primitive.ObjectID, set bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set["updated_at"] = time.Now()
+	result, err := chatCollection.UpdateOne(ctx, bson.M{"_id": id, "user_id": userID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
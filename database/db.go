@@ -17,18 +17,18 @@ type User struct {
 	Email     string            `bson:"email"`
 	Password  string            `bson:"password"`
 	CreatedAt time.Time         `bson:"created_at"`
-}
-
-type ChatHistory struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	UserID    primitive.ObjectID `bson:"user_id"`
-	Messages  []string          `bson:"messages"`
-	CreatedAt time.Time         `bson:"created_at"`
+	// Limits overrides the server's per-user limits when set.
+	Limits *Limits `bson:"limits,omitempty"`
 }
 
 var client *mongo.Client
 var userCollection *mongo.Collection
 var chatCollection *mongo.Collection
+var apiKeyCollection *mongo.Collection
+var personaCollection *mongo.Collection
+var usageCollection *mongo.Collection
+var rateLimitCollection *mongo.Collection
+var tokenCollection *mongo.Collection
 
 func InitDB() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -53,6 +53,79 @@ func InitDB() error {
 	// Initialize collections
 	userCollection = client.Database("askgpt").Collection("users")
 	chatCollection = client.Database("askgpt").Collection("chats")
+	apiKeyCollection = client.Database("askgpt").Collection("api_keys")
+	personaCollection = client.Database("askgpt").Collection("personas")
+	usageCollection = client.Database("askgpt").Collection("usage")
+	rateLimitCollection = client.Database("askgpt").Collection("rate_limits")
+	tokenCollection = client.Database("askgpt").Collection("daily_tokens")
+
+	// Conversations are listed per user, newest first
+	_, err = chatCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// API keys are looked up by hash on every proxied request
+	_, err = apiKeyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Persona names are unique per user
+	_, err = personaCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Usage is counted per user, day and model
+	_, err = usageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1}, {Key: "day", Value: 1},
+			{Key: "provider", Value: 1}, {Key: "model", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Request counters are kept per user and minute, then expire
+	_, err = rateLimitCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "window", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Token counters are kept per user and day, then expire
+	_, err = tokenCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -100,49 +173,26 @@ func AuthenticateUser(email, password string) (*User, error) {
 	return &user, nil
 }
 
-func SaveChatHistory(userID primitive.ObjectID, messages []string) error {
-	chat := ChatHistory{
-		UserID:    userID,
-		Messages:  messages,
-		CreatedAt: time.Now(),
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := chatCollection.InsertOne(ctx, chat)
-	return err
-}
-
-func GetChatHistory(userID primitive.ObjectID) ([]string, error) {
+func GetUserByID(id primitive.ObjectID) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var chat ChatHistory
-	err := chatCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&chat)
-	if err == mongo.ErrNoDocuments {
-		return []string{}, nil
-	} else if err != nil {
+	var user User
+	err := userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err != nil {
 		return nil, err
 	}
 
-	return chat.Messages, nil
-}
-
-func ClearChatHistory(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := chatCollection.DeleteOne(ctx, bson.M{"user_id": userID})
-	return err
+	return &user, nil
 }
 
-func GetUserByID(id primitive.ObjectID) (*User, error) {
+// GetUserByEmail returns the user registered with email.
+func GetUserByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var user User
-	err := userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
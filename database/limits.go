@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits caps what a user may spend on the models. Zero fields are
+// unlimited.
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute" bson:"requests_per_minute"`
+	TokensPerDay      int `json:"tokens_per_day" bson:"tokens_per_day"`
+}
+
+// CountRequest counts a request of userID in the minute starting at window
+// and returns how many that minute has seen, this one included. Counters
+// are stored in the rate_limits collection and expire after a few minutes.
+func CountRequest(userID primitive.ObjectID, window time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "window": window}
+	update := bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expires_at": window.Add(5 * time.Minute)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	if err := rateLimitCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter); err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+// ReserveTokens adds tokens to what userID has used or reserved on day, in
+// DayFormat, unless that would exceed limit; zero is unlimited. It returns
+// the total afterwards and whether the tokens were reserved. The check and
+// the increment are one atomic update, so concurrent requests cannot
+// overrun the limit together. Counters are stored in the daily_tokens
+// collection and expire a few days later.
+func ReserveTokens(userID primitive.ObjectID, day string, tokens, limit int) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start, err := time.Parse(DayFormat, day)
+	if err != nil {
+		return 0, false, err
+	}
+	filter := bson.M{"user_id": userID, "day": day}
+	update := bson.M{
+		"$inc":         bson.M{"tokens": tokens},
+		"$setOnInsert": bson.M{"expires_at": start.AddDate(0, 0, 3)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Tokens int `bson:"tokens"`
+	}
+	if err := tokenCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter); err != nil {
+		return 0, false, err
+	}
+	if limit > 0 && counter.Tokens > limit {
+		// Give the tokens back; concurrent requests may be refused
+		// meanwhile, but none is let through over the limit
+		if _, err := tokenCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"tokens": -tokens}}); err != nil {
+			return 0, false, err
+		}
+		return counter.Tokens - tokens, false, nil
+	}
+	return counter.Tokens, true, nil
+}
+
+// SettleTokens corrects the tokens of userID on day by delta once a
+// request's actual usage is known.
+func SettleTokens(userID primitive.ObjectID, day string, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := tokenCollection.UpdateOne(ctx, bson.M{"user_id": userID, "day": day}, bson.M{"$inc": bson.M{"tokens": delta}})
+	return err
+}
+
+// TokensUsed returns the tokens userID has used or reserved on day.
+func TokensUsed(userID primitive.ObjectID, day string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var counter struct {
+		Tokens int `bson:"tokens"`
+	}
+	err := tokenCollection.FindOne(ctx, bson.M{"user_id": userID, "day": day}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return counter.Tokens, nil
+}
+
+// SetUserLimits overrides the limits of userID; nil restores the server
+// defaults.
+func SetUserLimits(userID primitive.ObjectID, limits *Limits) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"limits": ""}}
+	if limits != nil {
+		update = bson.M{"$set": bson.M{"limits": limits}}
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ListLimitedUsers returns the users whose limits are overridden, by email.
+func ListLimitedUsers() ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "email", Value: 1}}).
+		SetProjection(bson.M{"password": 0})
+	cursor, err := userCollection.Find(ctx, bson.M{"limits": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
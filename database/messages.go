@@ -0,0 +1,61 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+
+	"askgo/llm"
+)
+
+// Legacy prefixes of the transcript lines stored before messages had roles.
+const (
+	legacyUserPrefix = "You: "
+	legacyAIPrefix   = "AI: "
+)
+
+// Messages is a stored chat history. Besides documents it decodes the
+// "You: ..." and "AI: ..." strings that histories were saved as before
+// messages carried roles, so old chats keep loading.
+type Messages []llm.Message
+
+// UnmarshalBSONValue implements bson.ValueUnmarshaler.
+func (m *Messages) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bson.TypeNull, bson.TypeUndefined:
+		*m = nil
+		return nil
+	case bson.TypeArray:
+	default:
+		return fmt.Errorf("messages: cannot decode %s", t)
+	}
+
+	values, err := bson.Raw(data).Values()
+	if err != nil {
+		return err
+	}
+	messages := make(Messages, 0, len(values))
+	for _, value := range values {
+		if text, ok := value.StringValueOK(); ok {
+			messages = append(messages, legacyMessage(text))
+			continue
+		}
+		var message llm.Message
+		if err := value.Unmarshal(&message); err != nil {
+			return err
+		}
+		messages = append(messages, message)
+	}
+	*m = messages
+	return nil
+}
+
+// legacyMessage turns a transcript line into a message with a role.
+func legacyMessage(text string) llm.Message {
+	if content, ok := strings.CutPrefix(text, legacyUserPrefix); ok {
+		return llm.Message{Role: llm.RoleUser, Content: content}
+	}
+	return llm.Message{Role: llm.RoleAssistant, Content: strings.TrimPrefix(text, legacyAIPrefix)}
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"askgo/llm"
+)
+
+func TestMessagesDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages interface{}
+		want     Messages
+	}{
+		{
+			"legacy strings",
+			[]string{"You: hello", "AI: <p>Hi!</p>", "You: AI: is a prefix", "no prefix"},
+			Messages{
+				{Role: llm.RoleUser, Content: "hello"},
+				{Role: llm.RoleAssistant, Content: "<p>Hi!</p>"},
+				{Role: llm.RoleUser, Content: "AI: is a prefix"},
+				{Role: llm.RoleAssistant, Content: "no prefix"},
+			},
+		},
+		{
+			"documents",
+			[]llm.Message{
+				{Role: llm.RoleUser, Content: "hello"},
+				{Role: llm.RoleAssistant, Content: "Hi!", Provider: "groq", Usage: &llm.Usage{TotalTokens: 3}},
+			},
+			Messages{
+				{Role: llm.RoleUser, Content: "hello"},
+				{Role: llm.RoleAssistant, Content: "Hi!", Provider: "groq", Usage: &llm.Usage{TotalTokens: 3}},
+			},
+		},
+		{
+			"mixed",
+			bson.A{"You: old", bson.M{"role": llm.RoleAssistant, "content": "new"}},
+			Messages{{Role: llm.RoleUser, Content: "old"}, {Role: llm.RoleAssistant, Content: "new"}},
+		},
+		{"empty", []string{}, Messages{}},
+		{"null", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(bson.M{"title": "old chat", "messages": tt.messages})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var conversation Conversation
+			if err := bson.Unmarshal(data, &conversation); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			if !reflect.DeepEqual(conversation.Messages, tt.want) {
+				t.Errorf("messages = %+v, want %+v", conversation.Messages, tt.want)
+			}
+			if conversation.Title != "old chat" {
+				t.Errorf("title = %q, want the rest of the document decoded", conversation.Title)
+			}
+		})
+	}
+}
+
+func TestMessagesDecodeRejectsOtherTypes(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"messages": "You: hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conversation Conversation
+	if err := bson.Unmarshal(data, &conversation); err == nil {
+		t.Errorf("decoded a string as %+v", conversation.Messages)
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"askgo/persona"
+)
+
+// ErrPersonaNotFound is returned when a user has no persona of that name.
+var ErrPersonaNotFound = errors.New("persona not found")
+
+// Persona is a persona saved by a user, stored in the personas collection.
+type Persona struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	UserID          primitive.ObjectID `bson:"user_id"`
+	persona.Persona `bson:",inline"`
+	CreatedAt       time.Time `bson:"created_at"`
+	UpdatedAt       time.Time `bson:"updated_at"`
+}
+
+// SavePersona creates or replaces the persona of userID with p's name.
+func SavePersona(userID primitive.ObjectID, p persona.Persona) (*Persona, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "name": p.Name}
+	update := bson.M{
+		"$set": bson.M{
+			"description": p.Description,
+			"system":      p.System,
+			"provider":    p.Provider,
+			"model":       p.Model,
+			"params":      p.Params,
+			"updated_at":  now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var saved Persona
+	if err := personaCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// ListPersonas returns the personas of userID sorted by name.
+func ListPersonas(userID primitive.ObjectID) ([]Persona, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := personaCollection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	personas := []Persona{}
+	if err := cursor.All(ctx, &personas); err != nil {
+		return nil, err
+	}
+	return personas, nil
+}
+
+// GetPersona loads the persona of userID called name.
+func GetPersona(userID primitive.ObjectID, name string) (*Persona, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var p Persona
+	err := personaCollection.FindOne(ctx, bson.M{"user_id": userID, "name": name}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPersonaNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeletePersona removes the persona of userID called name.
+func DeletePersona(userID primitive.ObjectID, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := personaCollection.DeleteOne(ctx, bson.M{"user_id": userID, "name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrPersonaNotFound
+	}
+	return nil
+}
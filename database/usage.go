@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"askgo/llm"
+)
+
+// DayFormat is the layout of UsageRecord.Day.
+const DayFormat = "2006-01-02"
+
+// UsageRecord is the token usage of one user with one model on one day
+// (UTC), stored in the usage collection.
+type UsageRecord struct {
+	UserID           primitive.ObjectID `bson:"user_id"`
+	Day              string             `bson:"day"`
+	Provider         string             `bson:"provider"`
+	Model            string             `bson:"model"`
+	Requests         int                `bson:"requests"`
+	PromptTokens     int                `bson:"prompt_tokens"`
+	CompletionTokens int                `bson:"completion_tokens"`
+}
+
+// Usage returns the record's token counts.
+func (r UsageRecord) Usage() llm.Usage {
+	return llm.Usage{
+		PromptTokens:     r.PromptTokens,
+		CompletionTokens: r.CompletionTokens,
+		TotalTokens:      r.PromptTokens + r.CompletionTokens,
+	}
+}
+
+// UsageFilter selects usage records. Zero fields match everything; From
+// and To are inclusive days in DayFormat.
+type UsageFilter struct {
+	UserID primitive.ObjectID
+	From   string
+	To     string
+}
+
+// RecordUsage adds one request of userID to provider and model, using
+// usage tokens, to today's totals.
+func RecordUsage(userID primitive.ObjectID, provider, model string, usage llm.Usage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":  userID,
+		"day":      time.Now().UTC().Format(DayFormat),
+		"provider": provider,
+		"model":    model,
+	}
+	update := bson.M{"$inc": bson.M{
+		"requests":          1,
+		"prompt_tokens":     usage.PromptTokens,
+		"completion_tokens": usage.CompletionTokens,
+	}}
+	_, err := usageCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// ListUsage returns the usage records matching filter, by day, provider
+// and model.
+func ListUsage(filter UsageFilter) ([]UsageRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	days := bson.M{}
+	if filter.From != "" {
+		days["$gte"] = filter.From
+	}
+	if filter.To != "" {
+		days["$lte"] = filter.To
+	}
+	if len(days) > 0 {
+		query["day"] = days
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: 1}, {Key: "provider", Value: 1}, {Key: "model", Value: 1}})
+	cursor, err := usageCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []UsageRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// UsageTotal sums the usage records sharing a key.
+type UsageTotal struct {
+	Key      string
+	Requests int
+	Usage    llm.Usage
+	// Cost is the estimated spend in US dollars. Unpriced is set when
+	// some of the tokens were used by models of unknown price, which
+	// Cost leaves out.
+	Cost     float64
+	Unpriced bool
+}
+
+// TotalUsage groups records by key, sorted by key, pricing each record
+// with cost.
+func TotalUsage(records []UsageRecord, key func(UsageRecord) string, cost func(UsageRecord) (float64, bool)) []UsageTotal {
+	index := make(map[string]int)
+	var totals []UsageTotal
+	for _, r := range records {
+		k := key(r)
+		i, ok := index[k]
+		if !ok {
+			i = len(totals)
+			index[k] = i
+			totals = append(totals, UsageTotal{Key: k})
+		}
+		t := &totals[i]
+		t.Requests += r.Requests
+		t.Usage = t.Usage.Add(r.Usage())
+		if c, ok := cost(r); ok {
+			t.Cost += c
+		} else {
+			t.Unpriced = true
+		}
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Key < totals[j].Key })
+	return totals
+}
@@ -1,60 +1,53 @@
+// Package gui is the Fyne desktop front-end.
 package gui
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"io"
-	"net/http"
-	"os"
 	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
-	"github.com/joho/godotenv"
+
+	"askgo/attach"
+	"askgo/llm"
+	"askgo/persona"
 )
 
-type GroqRequest struct {
-	Messages []Message `json:"messages"`
-	Model    string    `json:"model"`
-}
+// noPersona is the persona picker entry for chatting without a persona.
+const noPersona = "None"
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+// do hands widget updates from background goroutines to the UI goroutine.
+// Fyne's test driver runs them in place, so tests replace it to keep their
+// own widget access in step.
+var do = fyne.Do
 
-type GroqResponse struct {
-	Choices []Choice `json:"choices"`
-}
+// StartGUI opens the desktop chat window and blocks until it is closed.
+// personas fill the persona picker.
+func StartGUI(cfg llm.Config, personas []persona.Persona) {
+	// Create a new Fyne application
+	window := NewWindow(app.New(), llm.NewClient(cfg), personas)
 
-type Choice struct {
-	Message Message `json:"message"`
+	// Show and run
+	window.ShowAndRun()
 }
 
-func StartGUI() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		fmt.Println("Error loading .env file")
-		os.Exit(1)
-	}
-
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		fmt.Println("GROQ_API_KEY environment variable not set")
-		os.Exit(1)
-	}
-
-	// Create a new Fyne application
-	myApp := app.New()
-	window := myApp.NewWindow("AskGo AI Assistant")
+// NewWindow builds the chat window of a, answering through client.
+func NewWindow(a fyne.App, client *llm.Client, personas []persona.Persona) fyne.Window {
+	window := a.NewWindow("AskGo AI Assistant")
 	window.Resize(fyne.NewSize(800, 600))
 
 	// Create conversation history display
 	history := widget.NewTextGrid()
 	history.SetText("Welcome to AskGo AI Assistant!\n\n")
 
+	// Messages sent to the model on every turn
+	var conversation []llm.Message
+
 	// Create input field
 	input := widget.NewEntry()
 	input.SetPlaceHolder("Type your message here...")
@@ -62,14 +55,133 @@ func StartGUI() {
 	// Create send button
 	sendButton := widget.NewButton("Send", nil)
 
+	// Model picker, filled once the providers' model lists arrive
+	opts := llm.Options{Provider: client.Provider(), Model: client.Model()}
+	modelSelect := widget.NewSelect(nil, func(selected string) {
+		target := llm.ParseTarget(selected)
+		opts.Provider, opts.Model = target.Provider, target.Model
+	})
+	modelSelect.PlaceHolder = "Loading models..."
+	go func() {
+		catalog, err := client.Catalog(context.Background())
+		labels := make([]string, len(catalog))
+		for i, target := range catalog {
+			labels[i] = target.String()
+		}
+		if len(labels) == 0 {
+			labels = []string{llm.Target{Provider: opts.Provider, Model: opts.Model}.String()}
+		}
+		do(func() {
+			if err != nil && len(catalog) == 0 {
+				modelSelect.PlaceHolder = "Models unavailable"
+			}
+			modelSelect.Options = labels
+			modelSelect.SetSelected(llm.Target{Provider: opts.Provider, Model: opts.Model}.String())
+		})
+	}()
+
+	// Persona picker; the persona's system prompt is prepended to every
+	// request and its model and parameters become the current settings
+	var active persona.Persona
+	personaNames := []string{noPersona}
+	for _, p := range personas {
+		personaNames = append(personaNames, p.Name)
+	}
+	personaSelect := widget.NewSelect(personaNames, func(selected string) {
+		p, err := persona.Find(personas, selected)
+		if err != nil {
+			active = persona.Persona{}
+			return
+		}
+		active = p
+		if p.Provider != "" || p.Model != "" {
+			target := llm.Target{Provider: p.Provider, Model: p.Model}
+			if target.Provider == "" {
+				target.Provider = client.Provider()
+			}
+			if target.Model == "" {
+				target.Model = client.ProviderModel(target.Provider)
+			}
+			opts.Provider, opts.Model = target.Provider, target.Model
+			modelSelect.SetSelected(target.String())
+		}
+		opts.Params = p.Params
+	})
+	personaSelect.SetSelected(noPersona)
+
+	// Settings dialog for the sampling parameters; empty fields use the
+	// defaults
+	settingsButton := widget.NewButton("Settings", func() {
+		entries := make(map[string]*widget.Entry)
+		var items []*widget.FormItem
+		for _, name := range llm.ParamNames {
+			entry := widget.NewEntry()
+			entry.SetText(opts.Params.Get(name))
+			entry.SetPlaceHolder(client.Params().Get(name))
+			entries[name] = entry
+			items = append(items, widget.NewFormItem(name, entry))
+		}
+		dialog.ShowForm("Generation settings", "Apply", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			var params llm.Params
+			for _, name := range llm.ParamNames {
+				if err := params.Set(name, entries[name].Text); err != nil {
+					dialog.ShowError(err, window)
+					return
+				}
+			}
+			opts.Params = params
+		}, window)
+	})
+
+	// Files attached to the next message, read when they are picked
+	type upload struct {
+		name string
+		data []byte
+	}
+	var uploads []upload
+	attachLabel := widget.NewLabel("")
+	attachLabel.Hide()
+	attachButton := widget.NewButton("Attach", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			if reader == nil {
+				// Cancelled
+				return
+			}
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			uploads = append(uploads, upload{name: reader.URI().Name(), data: data})
+			names := make([]string, len(uploads))
+			for i, u := range uploads {
+				names[i] = u.name
+			}
+			attachLabel.SetText("Attached: " + strings.Join(names, ", "))
+			attachLabel.Show()
+		}, window)
+	})
+
 	// Create scroll container for history
 	scrollContainer := container.NewScroll(history)
 	scrollContainer.Resize(fyne.NewSize(800, 500))
 
 	// Create main container
 	content := container.NewBorder(
-		nil,
-		container.NewHBox(input, sendButton),
+		container.NewHBox(
+			widget.NewLabel("Persona:"), personaSelect,
+			widget.NewLabel("Model:"), modelSelect,
+			settingsButton,
+		),
+		container.NewVBox(attachLabel, container.NewHBox(attachButton, input, sendButton)),
 		nil,
 		nil,
 		scrollContainer,
@@ -80,6 +192,11 @@ func StartGUI() {
 
 	// Handle send button click
 	sendButton.OnTapped = func() {
+		// Ignore Enter while a reply is still streaming
+		if sendButton.Disabled() {
+			return
+		}
+
 		prompt := input.Text
 		if strings.TrimSpace(prompt) == "" {
 			return
@@ -89,65 +206,65 @@ func StartGUI() {
 		history.SetText(history.Text() + "You: " + prompt + "\n")
 		input.SetText("")
 
-		// Scroll to bottom
-		scrollContainer.ScrollToBottom()
-
-		// Prepare the request
-		requestBody := GroqRequest{
-			Messages: []Message{
-				{
-					Role:    "user",
-					Content: prompt,
-				},
-			},
-			Model: "llama3-8b-8192",
-		}
-
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			history.SetText(history.Text() + "Error: " + err.Error() + "\n")
-			return
+		// Inline the attached files and those referenced as @path
+		message := prompt
+		if refs := attach.References(prompt); len(uploads) > 0 || len(refs) > 0 {
+			set := attach.NewSet(attach.Budget(client.ContextWindow(opts.Provider, opts.Model)))
+			for _, u := range uploads {
+				set.AddData(u.name, u.data)
+			}
+			for _, path := range refs {
+				if err := set.Add(path); err != nil {
+					set.Skipped = append(set.Skipped, attach.Skipped{Path: path, Reason: err.Error()})
+				}
+			}
+			for _, f := range set.Files {
+				history.SetText(history.Text() + "Attached " + f.Path + "\n")
+			}
+			for _, skipped := range set.Skipped {
+				history.SetText(history.Text() + "Skipped " + skipped.Path + ": " + skipped.Reason + "\n")
+			}
+			message = set.Prompt(prompt)
+			uploads = nil
+			attachLabel.Hide()
 		}
 
-		// Create HTTP request
-		req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/chat/completions", strings.NewReader(string(jsonData)))
-		if err != nil {
-			history.SetText(history.Text() + "Error: " + err.Error() + "\n")
-			return
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-
-		// Send request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			history.SetText(history.Text() + "Error: " + err.Error() + "\n")
-			return
-		}
-		defer resp.Body.Close()
+		// Scroll to bottom
+		scrollContainer.ScrollToBottom()
 
-		// Read response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			history.SetText(history.Text() + "Error: " + err.Error() + "\n")
-			return
-		}
+		// Send request with the whole conversation so far. The request runs
+		// in the background so the window keeps repainting while tokens
+		// arrive; widget updates are handed back to the UI goroutine.
+		conversation = append(conversation, llm.Message{Role: llm.RoleUser, Content: message})
+		messages := active.Messages(append([]llm.Message(nil), conversation...))
+		callOpts := opts
+		sendButton.Disable()
+		history.SetText(history.Text() + "AI: ")
 
-		// Parse response
-		var groqResponse GroqResponse
-		if err := json.Unmarshal(body, &groqResponse); err != nil {
-			history.SetText(history.Text() + "Error: " + err.Error() + "\n")
-			return
-		}
+		go func() {
+			resp, err := client.ChatStream(context.Background(), messages, callOpts, func(delta string) error {
+				do(func() {
+					history.SetText(history.Text() + delta)
+					scrollContainer.ScrollToBottom()
+				})
+				return nil
+			})
 
-		// Add AI response to history
-		response := groqResponse.Choices[0].Message.Content
-		history.SetText(history.Text() + "AI: " + response + "\n\n")
+			do(func() {
+				defer sendButton.Enable()
+				if err != nil {
+					conversation = conversation[:len(conversation)-1]
+					history.SetText(history.Text() + "\n\n")
+					dialog.ShowError(errors.New(llm.UserMessage(err)), window)
+					return
+				}
+				conversation = append(conversation, resp.Message)
+				history.SetText(history.Text() + "\n\n")
 
-		// Scroll to bottom
-		scrollContainer.ScrollToBottom()
+				// Scroll to bottom
+				scrollContainer.ScrollToBottom()
+			})
+		}()
 	}
 
 	// Handle Enter key in input field
@@ -155,6 +272,5 @@ func StartGUI() {
 		sendButton.OnTapped()
 	}
 
-	// Show and run
-	window.ShowAndRun()
+	return window
 }
@@ -0,0 +1,140 @@
+package gui
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+
+	"askgo/llm"
+	"askgo/llm/llmtest"
+)
+
+// ui serializes the test's widget access with the window's background
+// updates.
+var ui sync.Mutex
+
+// newTestWindow opens the chat window on a test app, answering from fake.
+func newTestWindow(t *testing.T, fake *llmtest.FakeProvider) fyne.Window {
+	t.Helper()
+	oldDo := do
+	do = func(fn func()) {
+		ui.Lock()
+		defer ui.Unlock()
+		fn()
+	}
+	t.Cleanup(func() { do = oldDo })
+
+	ui.Lock()
+	defer ui.Unlock()
+	return NewWindow(test.NewApp(), llmtest.NewClient(fake), nil)
+}
+
+// find returns the objects of type T shown in window, dialogs included.
+func find[T fyne.CanvasObject](window fyne.Window) []T {
+	roots := append([]fyne.CanvasObject{window.Content()}, window.Canvas().Overlays().List()...)
+	var found []T
+	for _, root := range roots {
+		for _, o := range test.LaidOutObjects(root) {
+			if t, ok := o.(T); ok {
+				found = append(found, t)
+			}
+		}
+	}
+	return found
+}
+
+// send types prompt into the window's input and presses Send.
+func send(t *testing.T, window fyne.Window, prompt string) {
+	t.Helper()
+	ui.Lock()
+	defer ui.Unlock()
+	test.Type(find[*widget.Entry](window)[0], prompt)
+	for _, button := range find[*widget.Button](window) {
+		if button.Text == "Send" {
+			test.Tap(button)
+			return
+		}
+	}
+	t.Fatal("no Send button")
+}
+
+// waitFor polls the UI until cond holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var ok bool
+		ui.Lock()
+		ok = cond()
+		ui.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func transcript(window fyne.Window) string {
+	return find[*widget.TextGrid](window)[0].Text()
+}
+
+func TestSendStreamsReply(t *testing.T) {
+	fake := llmtest.NewFakeProvider(
+		llmtest.Reply{Chunks: []string{"Hello", " there", "!"}},
+		llmtest.Reply{Content: "Fine."},
+	)
+	window := newTestWindow(t, fake)
+
+	send(t, window, "Hi")
+	waitFor(t, "the reply", func() bool {
+		return strings.Contains(transcript(window), "You: Hi\nAI: Hello there!\n\n")
+	})
+
+	// The next turn carries the history
+	send(t, window, "How are you?")
+	waitFor(t, "the second reply", func() bool {
+		return strings.Contains(transcript(window), "AI: Fine.")
+	})
+	requests := fake.Requests()
+	if len(requests) != 2 || len(requests[1].Messages) != 3 {
+		t.Fatalf("requests = %+v, want the second with the whole conversation", requests)
+	}
+	if got := requests[1].Messages[1].Content; got != "Hello there!" {
+		t.Errorf("history sent %q, want the streamed reply", got)
+	}
+}
+
+func TestSendShowsError(t *testing.T) {
+	fake := llmtest.NewFakeProvider(llmtest.Reply{Err: &llm.StatusError{StatusCode: http.StatusBadRequest, Kind: llm.ErrBadRequest}})
+	window := newTestWindow(t, fake)
+
+	send(t, window, "Hi")
+	want := llm.UserMessage(&llm.StatusError{StatusCode: http.StatusBadRequest, Kind: llm.ErrBadRequest})
+	waitFor(t, "the error dialog", func() bool {
+		for _, label := range find[*widget.Label](window) {
+			if label.Text == want {
+				return true
+			}
+		}
+		return false
+	})
+
+	// The failed message is not kept, so the next one goes alone
+	fake.Push(llmtest.Reply{Content: "Back."})
+	send(t, window, "Again")
+	waitFor(t, "the reply after the error", func() bool {
+		return strings.Contains(transcript(window), "AI: Back.")
+	})
+	if requests := fake.Requests(); len(requests[1].Messages) != 1 {
+		t.Errorf("second request sent %d messages, want 1", len(requests[1].Messages))
+	}
+}
@@ -0,0 +1,228 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version sent with every request.
+const anthropicVersion = "2023-06-01"
+
+// anthropicMaxTokens is sent because the Messages API requires a limit.
+const anthropicMaxTokens = 4096
+
+// anthropicProvider speaks Anthropic's Messages API, which takes system
+// prompts as a separate field and reports usage as input/output tokens.
+type anthropicProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+type anthropicRequest struct {
+	Model         string        `json:"model"`
+	System        string        `json:"system,omitempty"`
+	Messages      []wireMessage `json:"messages"`
+	MaxTokens     int           `json:"max_tokens"`
+	Temperature   *float64      `json:"temperature,omitempty"`
+	TopP          *float64      `json:"top_p,omitempty"`
+	StopSequences []string      `json:"stop_sequences,omitempty"`
+	Stream        bool          `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+// anthropicEvent covers the stream events askGPT reads: message_start,
+// content_block_delta, message_delta and error.
+type anthropicEvent struct {
+	Type    string             `json:"type"`
+	Message *anthropicResponse `json:"message"`
+	Delta   struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *APIError       `json:"error"`
+}
+
+func toUsage(u anthropicUsage) Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// anthropicFinishReason translates a stop_reason into OpenAI's terms.
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "":
+		return ""
+	case "max_tokens":
+		return FinishLength
+	}
+	return FinishStop
+}
+
+func (p *anthropicProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.send(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("llm: read response: %w", err)
+	}
+
+	var reply anthropicResponse
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("llm: parse response: %w", err)
+	}
+	if len(reply.Content) == 0 {
+		return nil, ErrNoChoices
+	}
+
+	var content strings.Builder
+	for _, block := range reply.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	return &Response{
+		Message:      Message{Role: RoleAssistant, Content: content.String()},
+		Model:        reply.Model,
+		Usage:        toUsage(reply.Usage),
+		FinishReason: anthropicFinishReason(reply.StopReason),
+	}, nil
+}
+
+func (p *anthropicProvider) ChatStream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	resp, err := p.send(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var usage anthropicUsage
+	model, stopReason := "", ""
+	received := false
+	err = readEvents(resp.Body, func(data string) error {
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("llm: parse stream chunk: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			received = true
+			if event.Message != nil {
+				model = event.Message.Model
+				usage.InputTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_delta":
+			if event.Delta.Text == "" {
+				return nil
+			}
+			content.WriteString(event.Delta.Text)
+			if onDelta != nil {
+				return onDelta(event.Delta.Text)
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+			if event.Delta.StopReason != "" {
+				stopReason = event.Delta.StopReason
+			}
+		case "error":
+			if event.Error != nil {
+				return streamError(event.Error, resp.Header)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !received {
+		return nil, ErrNoChoices
+	}
+
+	return &Response{
+		Message:      Message{Role: RoleAssistant, Content: content.String()},
+		Model:        model,
+		Usage:        toUsage(usage),
+		FinishReason: anthropicFinishReason(stopReason),
+	}, nil
+}
+
+// Models lists the models available to the API key.
+func (p *anthropicProvider) Models(ctx context.Context) ([]ModelInfo, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, p.httpClient, p.baseURL+"/v1/models?limit=1000", p.header(), &list); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, len(list.Data))
+	for i, m := range list.Data {
+		models[i] = ModelInfo{ID: m.ID}
+	}
+	return models, nil
+}
+
+func (p *anthropicProvider) header() http.Header {
+	header := http.Header{}
+	header.Set("x-api-key", p.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	return header
+}
+
+// send converts req to the Messages API shape: system messages are joined
+// into the top-level system field. The API has no seed parameter.
+func (p *anthropicProvider) send(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	body := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     anthropicMaxTokens,
+		Temperature:   req.Params.Temperature,
+		TopP:          req.Params.TopP,
+		StopSequences: req.Params.Stop,
+		Stream:        stream,
+	}
+	if req.Params.MaxTokens != nil {
+		body.MaxTokens = *req.Params.MaxTokens
+	}
+	var system []string
+	for _, m := range req.Messages {
+		if m.Role == RoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		body.Messages = append(body.Messages, wireMessage{Role: m.Role, Content: m.Content})
+	}
+	body.System = strings.Join(system, "\n\n")
+
+	return postJSON(ctx, p.httpClient, p.baseURL+"/v1/messages", p.header(), body, stream)
+}
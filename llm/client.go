@@ -0,0 +1,572 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Built-in provider names.
+const (
+	ProviderGroq      = "groq"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+	ProviderAnthropic = "anthropic"
+)
+
+// Defaults used when a Config field is left empty.
+const (
+	DefaultProvider = ProviderGroq
+	DefaultBaseURL  = "https://api.groq.com/openai/v1"
+	DefaultModel    = "llama3-8b-8192"
+	DefaultTimeout  = 60 * time.Second
+)
+
+// ErrNoChoices is returned when the API answers without any completion.
+var ErrNoChoices = errors.New("llm: response contained no choices")
+
+// ErrUnknownProvider is returned when Options.Provider names no configured
+// backend.
+var ErrUnknownProvider = errors.New("llm: unknown provider")
+
+// builtinProvider describes the defaults of a known backend.
+type builtinProvider struct {
+	baseURL string
+	model   string
+	// keyEnv is the environment variable holding the API key, empty for
+	// backends that do not need one.
+	keyEnv string
+}
+
+var builtinProviders = map[string]builtinProvider{
+	ProviderGroq:      {baseURL: DefaultBaseURL, model: DefaultModel, keyEnv: "GROQ_API_KEY"},
+	ProviderOpenAI:    {baseURL: "https://api.openai.com/v1", model: "gpt-4o-mini", keyEnv: "OPENAI_API_KEY"},
+	ProviderOllama:    {baseURL: "http://localhost:11434", model: "llama3"},
+	ProviderAnthropic: {baseURL: "https://api.anthropic.com", model: "claude-3-5-haiku-latest", keyEnv: "ANTHROPIC_API_KEY"},
+}
+
+// ProviderConfig configures one backend. Names other than the built-in
+// providers are treated as OpenAI-compatible endpoints and need a BaseURL.
+type ProviderConfig struct {
+	BaseURL string
+	APIKey  string
+	// Model is used when a request does not name one.
+	Model string
+}
+
+// Config configures a Client.
+type Config struct {
+	// Provider names the default backend, DefaultProvider when empty.
+	Provider string
+	// BaseURL, APIKey and Model configure the default backend and take
+	// precedence over its entry in Providers.
+	BaseURL string
+	APIKey  string
+	Model   string
+	// Timeout bounds the wait for a backend to start answering and each
+	// non-streamed call, DefaultTimeout when zero. Streams run as long as
+	// the caller's context allows.
+	Timeout time.Duration
+	// Retry controls retries of rate-limited and failed calls.
+	Retry RetryPolicy
+	// Transport replaces http.DefaultTransport for upstream calls, e.g. to
+	// record or replay exchanges.
+	Transport http.RoundTripper
+	// Providers configures the backends selectable with Options.Provider.
+	Providers map[string]ProviderConfig
+	// Params are the default sampling parameters of every request.
+	Params Params
+	// Fallbacks are tried in order when the requested backend is rate
+	// limited, failing, unreachable or no longer serves the model.
+	// Entries naming unconfigured providers are skipped.
+	Fallbacks []Target
+	// ContextWindows overrides the context length of models, in tokens,
+	// by model name.
+	ContextWindows map[string]int
+	// Truncator shortens histories that do not fit the context window,
+	// DropOldest when nil.
+	Truncator Truncator
+	// Prices overrides the price of models by model name, for cost
+	// estimates.
+	Prices map[string]Price
+}
+
+// ConfigFromEnv builds a Config from the environment. ASKGO_PROVIDER picks
+// the default backend; GROQ_API_KEY, OPENAI_API_KEY, ASKGO_OPENAI_BASE_URL,
+// OLLAMA_HOST and ANTHROPIC_API_KEY configure the individual backends, and
+// ASKGO_BASE_URL, ASKGO_MODEL and ASKGO_TIMEOUT override the default one.
+// ASKGO_MAX_RETRIES (0 disables retries) and ASKGO_RETRY_BUDGET tune
+// retries, and ASKGO_FALLBACKS lists fallback targets (see ParseTargets).
+// ASKGO_TEMPERATURE, ASKGO_TOP_P, ASKGO_MAX_TOKENS, ASKGO_STOP and
+// ASKGO_SEED set default sampling parameters, and ASKGO_CONTEXT_WINDOWS
+// overrides context lengths (see ParseContextWindows) and ASKGO_PRICES
+// model prices (see ParsePrices). Malformed durations, numbers and
+// parameters are reported in the returned error, together with the Config
+// built from the rest.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Provider: os.Getenv("ASKGO_PROVIDER"),
+		BaseURL:  os.Getenv("ASKGO_BASE_URL"),
+		Model:    os.Getenv("ASKGO_MODEL"),
+		Providers: map[string]ProviderConfig{
+			ProviderGroq:      {APIKey: os.Getenv("GROQ_API_KEY")},
+			ProviderOpenAI:    {APIKey: os.Getenv("OPENAI_API_KEY"), BaseURL: os.Getenv("ASKGO_OPENAI_BASE_URL")},
+			ProviderOllama:    {BaseURL: ollamaURL(os.Getenv("OLLAMA_HOST"))},
+			ProviderAnthropic: {APIKey: os.Getenv("ANTHROPIC_API_KEY")},
+		},
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = DefaultProvider
+	}
+	cfg.APIKey = cfg.Providers[cfg.Provider].APIKey
+
+	var errs []error
+	if value := os.Getenv("ASKGO_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ASKGO_TIMEOUT: %q is not a duration such as 90s", value))
+		}
+		cfg.Timeout = timeout
+	}
+	if value := os.Getenv("ASKGO_MAX_RETRIES"); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			errs = append(errs, fmt.Errorf("ASKGO_MAX_RETRIES: %q is not a number of retries", value))
+		} else if retries == 0 {
+			cfg.Retry.MaxRetries = -1
+		} else {
+			cfg.Retry.MaxRetries = retries
+		}
+	}
+	if value := os.Getenv("ASKGO_RETRY_BUDGET"); value != "" {
+		budget, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ASKGO_RETRY_BUDGET: %q is not a duration such as 2m", value))
+		}
+		cfg.Retry.Budget = budget
+	}
+	cfg.Fallbacks = ParseTargets(os.Getenv("ASKGO_FALLBACKS"))
+	cfg.ContextWindows = ParseContextWindows(os.Getenv("ASKGO_CONTEXT_WINDOWS"))
+	cfg.Prices = ParsePrices(os.Getenv("ASKGO_PRICES"))
+	for _, name := range ParamNames {
+		env := "ASKGO_" + strings.ToUpper(name)
+		if value := os.Getenv(env); value != "" {
+			if err := cfg.Params.Set(name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// ParseTargets parses a comma-separated list of provider:model pairs such
+// as "groq:llama3-70b-8192,ollama". A bare provider name means its default
+// model.
+func ParseTargets(s string) []Target {
+	var targets []Target
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		targets = append(targets, ParseTarget(field))
+	}
+	return targets
+}
+
+// ParseContextWindows parses a comma-separated list of model=tokens pairs
+// such as "llama3:8b=8192,my-model=32768". Malformed entries are ignored.
+func ParseContextWindows(s string) map[string]int {
+	windows := make(map[string]int)
+	for _, field := range strings.Split(s, ",") {
+		model, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n > 0 {
+			windows[strings.TrimSpace(model)] = n
+		}
+	}
+	return windows
+}
+
+// ParseTarget parses a single "provider:model" pair as written by
+// Target.String. Ollama tags such as "ollama:llama3:8b" keep everything
+// after the first colon as the model.
+func ParseTarget(s string) Target {
+	provider, model, _ := strings.Cut(s, ":")
+	return Target{Provider: provider, Model: model}
+}
+
+// ollamaURL turns an OLLAMA_HOST value such as "127.0.0.1:11434" into a URL.
+func ollamaURL(host string) string {
+	if host == "" || strings.Contains(host, "://") {
+		return host
+	}
+	return "http://" + host
+}
+
+// Validate reports whether the backend called provider is usable, the
+// default one when provider is empty. Commands check the backend they are
+// about to use, so that a missing key of another one does not stop them.
+func (cfg Config) Validate(provider string) error {
+	def := cfg.Provider
+	if def == "" {
+		def = DefaultProvider
+	}
+	if provider == "" {
+		provider = def
+	}
+
+	pc := cfg.Providers[provider]
+	if provider == def {
+		if cfg.BaseURL != "" {
+			pc.BaseURL = cfg.BaseURL
+		}
+		if cfg.APIKey != "" {
+			pc.APIKey = cfg.APIKey
+		}
+	}
+
+	builtin, ok := builtinProviders[provider]
+	if !ok {
+		if pc.BaseURL == "" {
+			return fmt.Errorf("llm: provider %q needs a base URL", provider)
+		}
+		return nil
+	}
+	if builtin.keyEnv != "" && pc.APIKey == "" {
+		return fmt.Errorf("%s environment variable not set", builtin.keyEnv)
+	}
+	return nil
+}
+
+// Client sends chat requests to one of several provider backends.
+type Client struct {
+	provider   string
+	providers  map[string]Provider
+	models     map[string]string
+	fallbacks  []Target
+	params     Params
+	retry      RetryPolicy
+	timeout    time.Duration
+	httpClient *http.Client
+	modelCache modelCache
+	// contextWindows and truncator keep requests within context limits
+	contextWindows map[string]int
+	truncator      Truncator
+	prices         map[string]Price
+}
+
+// NewClient returns a Client for cfg, filling in defaults for empty fields.
+func NewClient(cfg Config) *Client {
+	if cfg.Provider == "" {
+		cfg.Provider = DefaultProvider
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Truncator == nil {
+		cfg.Truncator = DropOldest{}
+	}
+	transport := cfg.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	c := &Client{
+		provider:   cfg.Provider,
+		providers:  make(map[string]Provider),
+		models:     make(map[string]string),
+		fallbacks:  cfg.Fallbacks,
+		params:     cfg.Params,
+		retry:      cfg.Retry.withDefaults(),
+		timeout:    cfg.Timeout,
+		httpClient: &http.Client{Transport: headerTimeout{next: transport, timeout: cfg.Timeout}},
+		modelCache: modelCache{entries: make(map[string]modelCacheEntry)},
+
+		contextWindows: cfg.ContextWindows,
+		truncator:      cfg.Truncator,
+		prices:         cfg.Prices,
+	}
+
+	providers := make(map[string]ProviderConfig, len(cfg.Providers)+1)
+	for name, pc := range cfg.Providers {
+		providers[name] = pc
+	}
+	def := providers[cfg.Provider]
+	if cfg.BaseURL != "" {
+		def.BaseURL = cfg.BaseURL
+	}
+	if cfg.APIKey != "" {
+		def.APIKey = cfg.APIKey
+	}
+	if cfg.Model != "" {
+		def.Model = cfg.Model
+	}
+	providers[cfg.Provider] = def
+
+	for name, pc := range providers {
+		builtin := builtinProviders[name]
+		if pc.BaseURL == "" {
+			pc.BaseURL = builtin.baseURL
+		}
+		if pc.Model == "" {
+			pc.Model = builtin.model
+		}
+		if p := newProvider(name, pc, c.httpClient); p != nil {
+			c.RegisterProvider(name, p, pc.Model)
+		}
+	}
+	return c
+}
+
+// newProvider builds the backend for name, or nil when it has no endpoint.
+func newProvider(name string, pc ProviderConfig, httpClient *http.Client) Provider {
+	if pc.BaseURL == "" {
+		return nil
+	}
+	baseURL := strings.TrimRight(pc.BaseURL, "/")
+	switch name {
+	case ProviderOllama:
+		return &ollamaProvider{baseURL: baseURL, httpClient: httpClient}
+	case ProviderAnthropic:
+		return &anthropicProvider{baseURL: baseURL, apiKey: pc.APIKey, httpClient: httpClient}
+	default:
+		return &openAIProvider{baseURL: baseURL, apiKey: pc.APIKey, httpClient: httpClient}
+	}
+}
+
+// RegisterProvider makes p selectable as name, answering with model when a
+// request does not name one. It replaces any provider of the same name and
+// is not safe to call concurrently with requests.
+func (c *Client) RegisterProvider(name string, p Provider, model string) {
+	c.providers[name] = p
+	c.models[name] = model
+	delete(c.modelCache.entries, name)
+}
+
+// Provider returns the name of the default backend.
+func (c *Client) Provider() string {
+	return c.provider
+}
+
+// Providers returns the names of all configured backends, sorted.
+func (c *Client) Providers() []string {
+	names := make([]string, 0, len(c.providers))
+	for name := range c.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Model returns the model used when Options.Model is empty.
+func (c *Client) Model() string {
+	return c.models[c.provider]
+}
+
+// ProviderModel returns the default model of the named backend, empty if it
+// is not configured.
+func (c *Client) ProviderModel(provider string) string {
+	return c.models[provider]
+}
+
+// Params returns the default sampling parameters.
+func (c *Client) Params() Params {
+	return c.params
+}
+
+// Fallbacks returns the configured fallback targets in order.
+func (c *Client) Fallbacks() []Target {
+	return append([]Target(nil), c.fallbacks...)
+}
+
+// Chat sends messages to the selected backend and returns the reply.
+func (c *Client) Chat(ctx context.Context, messages []Message, opts Options) (*Response, error) {
+	return c.walk(ctx, messages, opts, func(t target) (*Response, bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		resp, err := t.provider.Chat(ctx, t.req)
+		return resp, false, err
+	})
+}
+
+// ChatStream is like Chat but streams the reply, calling onDelta for every
+// content fragment as it arrives. The returned Response holds the assembled
+// reply.
+func (c *Client) ChatStream(ctx context.Context, messages []Message, opts Options, onDelta DeltaFunc) (*Response, error) {
+	// A stream is only retried or handed to a fallback while nothing has
+	// been passed to onDelta, so callers never see a fragment twice
+	return c.walk(ctx, messages, opts, func(t target) (*Response, bool, error) {
+		started := false
+		resp, err := t.provider.ChatStream(ctx, t.req, func(delta string) error {
+			started = true
+			if onDelta == nil {
+				return nil
+			}
+			return onDelta(delta)
+		})
+		return resp, started, err
+	})
+}
+
+// target is a resolved backend and the request to send it.
+type target struct {
+	name     string
+	provider Provider
+	req      Request
+	// truncated counts the history messages left out of req
+	truncated int
+}
+
+// maxShrinks bounds how often a request rejected for its length is cut
+// down further and sent again.
+const maxShrinks = 2
+
+// walk sends the request to the requested backend and then to each
+// fallback until one answers. Only the last candidate is retried, so a
+// rate-limited backend hands over immediately instead of backing off.
+// Each candidate gets the history truncated to its own context window; if
+// the backend still finds it too long the budget shrinks and it is sent
+// again.
+func (c *Client) walk(ctx context.Context, messages []Message, opts Options, send func(target) (*Response, bool, error)) (*Response, error) {
+	targets, err := c.targets(messages, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, t := range targets {
+		policy := c.retry
+		if i < len(targets)-1 {
+			policy.MaxRetries = -1
+		}
+
+		budget := c.promptBudget(t)
+		var resp *Response
+		var started bool
+		for shrinks := 0; ; shrinks++ {
+			if t, err = c.fit(ctx, t, messages, budget); err != nil {
+				return nil, err
+			}
+			err = policy.retry(ctx, func() (bool, error) {
+				var err error
+				resp, started, err = send(t)
+				return started, err
+			})
+			if err == nil {
+				resp = finish(resp, t.name, t.req)
+				resp.Truncated = t.truncated
+				return resp, nil
+			}
+			// Our token count is an estimate; cut deeper if it was off
+			if started || !errors.Is(err, ErrContextLength) || shrinks == maxShrinks {
+				break
+			}
+			if budget = min(budget, CountMessages(t.req.Messages)) * 3 / 4; budget <= 0 {
+				break
+			}
+		}
+		if started || !fallbackable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// promptBudget returns how many prompt tokens fit in the context window of
+// t's model once room for the reply is set aside: MaxTokens when set, else
+// a quarter of the window up to 1024 tokens.
+func (c *Client) promptBudget(t target) int {
+	window := c.ContextWindow(t.name, t.req.Model)
+	reserve := min(1024, window/4)
+	if t.req.Params.MaxTokens != nil {
+		reserve = *t.req.Params.MaxTokens
+	}
+	return window - reserve
+}
+
+// fit returns t with messages truncated to budget tokens.
+func (c *Client) fit(ctx context.Context, t target, messages []Message, budget int) (target, error) {
+	t.req.Messages, t.truncated = messages, 0
+	if CountMessages(messages) <= budget {
+		return t, nil
+	}
+	fitted, err := c.truncator.Truncate(ctx, messages, budget)
+	if err != nil {
+		return t, fmt.Errorf("llm: truncate history: %w", err)
+	}
+	t.req.Messages, t.truncated = fitted, max(len(messages)-len(fitted), 0)
+	return t, nil
+}
+
+// targets resolves the requested backend followed by the configured
+// fallbacks, skipping duplicates and unconfigured providers.
+func (c *Client) targets(messages []Message, opts Options) ([]target, error) {
+	name := opts.Provider
+	if name == "" {
+		name = c.provider
+	}
+	if _, ok := c.providers[name]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, name)
+	}
+
+	var targets []target
+	seen := make(map[Target]bool)
+	for _, t := range append([]Target{{Provider: name, Model: opts.Model}}, c.fallbacks...) {
+		p, ok := c.providers[t.Provider]
+		if !ok {
+			continue
+		}
+		if t.Model == "" {
+			t.Model = c.models[t.Provider]
+		}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		targets = append(targets, target{
+			name:     t.Provider,
+			provider: p,
+			req:      Request{Messages: messages, Model: t.Model, Params: c.params.Merge(opts.Params)},
+		})
+	}
+	return targets, nil
+}
+
+// fallbackable reports whether err should hand the request to the next
+// target: the backend is overloaded, down, unreachable or lacks the model.
+func fallbackable(err error) bool {
+	if errors.Is(err, ErrModelUnavailable) {
+		return true
+	}
+	return retryable(err)
+}
+
+// finish fills in the fields a provider may leave empty and records on the
+// message which backend wrote it.
+func finish(resp *Response, provider string, req Request) *Response {
+	resp.Provider = provider
+	if resp.Model == "" {
+		resp.Model = req.Model
+	}
+	if resp.Message.Role == "" {
+		resp.Message.Role = RoleAssistant
+	}
+	if resp.Usage.TotalTokens == 0 {
+		resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+	}
+	resp.Message.Model = resp.Model
+	resp.Message.Provider = resp.Provider
+	if resp.Usage != (Usage{}) {
+		usage := resp.Usage
+		resp.Message.Usage = &usage
+	}
+	return resp
+}
@@ -0,0 +1,207 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the variables ConfigFromEnv reads for the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"ASKGO_PROVIDER", "ASKGO_BASE_URL", "ASKGO_MODEL", "ASKGO_TIMEOUT",
+		"ASKGO_MAX_RETRIES", "ASKGO_RETRY_BUDGET", "ASKGO_FALLBACKS",
+		"GROQ_API_KEY", "OPENAI_API_KEY", "ANTHROPIC_API_KEY", "OLLAMA_HOST",
+	} {
+		t.Setenv(name, "")
+	}
+	for _, name := range ParamNames {
+		t.Setenv("ASKGO_"+strings.ToUpper(name), "")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{"", "GROQ_API_KEY"},
+		{ProviderGroq, "GROQ_API_KEY"},
+		{ProviderOpenAI, "OPENAI_API_KEY"},
+		{ProviderOllama, ""},
+		{ProviderAnthropic, ""},
+		{"local", "needs a base URL"},
+	}
+	for _, tt := range tests {
+		err := cfg.Validate(tt.provider)
+		if tt.want == "" && err != nil {
+			t.Errorf("Validate(%q) = %v, want nil", tt.provider, err)
+		} else if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("Validate(%q) = %v, want an error mentioning %s", tt.provider, err, tt.want)
+		}
+	}
+
+	// Settings of the default backend count for it only
+	cfg = Config{Provider: "local", BaseURL: "http://localhost:8000/v1"}
+	if err := cfg.Validate(""); err != nil {
+		t.Errorf("default backend with a base URL: %v", err)
+	}
+	if err := cfg.Validate("other"); err == nil {
+		t.Error("another custom backend borrowed the default's base URL")
+	}
+	cfg = Config{Provider: ProviderOllama, APIKey: "sk-groq"}
+	if err := cfg.Validate(ProviderGroq); err == nil {
+		t.Error("groq borrowed the API key of the default backend")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("ASKGO_PROVIDER", ProviderOllama)
+	t.Setenv("ASKGO_TIMEOUT", "90s")
+	t.Setenv("ASKGO_MAX_RETRIES", "0")
+	t.Setenv("ASKGO_TEMPERATURE", "0.5")
+	t.Setenv("ASKGO_STOP", "END,STOP")
+
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Provider != ProviderOllama || cfg.Timeout != 90*time.Second || cfg.Retry.MaxRetries != -1 {
+		t.Errorf("cfg = %+v, want ollama, 90s and retries off", cfg)
+	}
+	if cfg.Params.Temperature == nil || *cfg.Params.Temperature != 0.5 || len(cfg.Params.Stop) != 2 {
+		t.Errorf("params = %+v, want the temperature and both stop sequences", cfg.Params)
+	}
+}
+
+func TestConfigFromEnvErrors(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("ASKGO_TEMPERATURE", "hot")
+	t.Setenv("ASKGO_MAX_TOKENS", "-3")
+	t.Setenv("ASKGO_TIMEOUT", "soon")
+	t.Setenv("ASKGO_MAX_RETRIES", "many")
+	t.Setenv("ASKGO_SEED", "7")
+
+	cfg, err := ConfigFromEnv()
+	if err == nil {
+		t.Fatal("malformed variables were ignored")
+	}
+	for _, name := range []string{"ASKGO_TEMPERATURE", "ASKGO_MAX_TOKENS", "ASKGO_TIMEOUT", "ASKGO_MAX_RETRIES"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+	// The valid settings still apply
+	if cfg.Params.Seed == nil || *cfg.Params.Seed != 7 || cfg.Params.Temperature != nil {
+		t.Errorf("params = %+v, want only the seed", cfg.Params)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		stream   bool
+		body     string
+		want     string
+	}{
+		{"openai", ProviderOpenAI, false,
+			`{"model":"m","choices":[{"message":{"role":"assistant","content":"cut"},"finish_reason":"length"}]}`, FinishLength},
+		{"openai stream", ProviderOpenAI, true,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"cut\"},\"finish_reason\":null}]}\n\ndata: {\"choices\":[{\"delta\":{},\"finish_reason\":\"length\"}]}\n\ndata: [DONE]\n\n", FinishLength},
+		{"anthropic", ProviderAnthropic, false,
+			`{"model":"m","content":[{"type":"text","text":"cut"}],"stop_reason":"max_tokens"}`, FinishLength},
+		{"anthropic end of turn", ProviderAnthropic, false,
+			`{"model":"m","content":[{"type":"text","text":"done"}],"stop_reason":"end_turn"}`, FinishStop},
+		{"anthropic stream", ProviderAnthropic, true,
+			"data: {\"type\":\"message_start\",\"message\":{\"model\":\"m\"}}\n\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"cut\"}}\n\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"max_tokens\"},\"usage\":{\"output_tokens\":1}}\n\n", FinishLength},
+		{"ollama", ProviderOllama, false,
+			`{"model":"m","message":{"role":"assistant","content":"cut"},"done":true,"done_reason":"length"}`, FinishLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+			client := NewClient(Config{Provider: tt.provider, BaseURL: server.URL, APIKey: "key", Retry: RetryPolicy{MaxRetries: -1}})
+
+			messages := []Message{{Role: RoleUser, Content: "hi"}}
+			var resp *Response
+			var err error
+			if tt.stream {
+				resp, err = client.ChatStream(context.Background(), messages, Options{}, nil)
+			} else {
+				resp, err = client.Chat(context.Background(), messages, Options{})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.FinishReason != tt.want {
+				t.Errorf("FinishReason = %q, want %q", resp.FinishReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "event-stream") {
+			// Headers come at once, the stream outlasts the timeout
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, word := range []string{"slow ", "but ", "complete"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word)
+				w.(http.Flusher).Flush()
+				time.Sleep(80 * time.Millisecond)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, `{"model":"m","choices":[{"message":{"role":"assistant","content":"late"}}]}`)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Provider: ProviderOpenAI, BaseURL: server.URL, APIKey: "key", Timeout: 100 * time.Millisecond, Retry: RetryPolicy{MaxRetries: -1}})
+	messages := []Message{{Role: RoleUser, Content: "hi"}}
+
+	resp, err := client.ChatStream(context.Background(), messages, Options{}, nil)
+	if err != nil {
+		t.Fatalf("stream longer than the timeout: %v", err)
+	}
+	if resp.Message.Content != "slow but complete" {
+		t.Errorf("stream = %q, want all of it", resp.Message.Content)
+	}
+
+	if _, err := client.Chat(context.Background(), messages, Options{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow answer = %v, want a deadline error", err)
+	}
+}
+
+func TestRetryCancelledDuringBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Minute, MaxDelay: time.Minute, Budget: time.Hour}
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable, Kind: ErrServer, RetryAfter: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := policy.retry(ctx, func() (bool, error) { return false, unavailable })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("retry = %v, want the cancellation rather than the last upstream error", err)
+	}
+}
@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classes of upstream failures. A *StatusError unwraps to one of them, so
+// callers can test with errors.Is(err, llm.ErrRateLimited).
+var (
+	ErrRateLimited   = errors.New("llm: rate limited")
+	ErrAuth          = errors.New("llm: authentication failed")
+	ErrContextLength = errors.New("llm: context length exceeded")
+	ErrServer        = errors.New("llm: upstream server error")
+	ErrBadRequest    = errors.New("llm: request rejected")
+	// ErrModelUnavailable means the model does not exist (anymore) on the
+	// backend, e.g. because it was decommissioned.
+	ErrModelUnavailable = errors.New("llm: model unavailable")
+)
+
+// maxErrorBody bounds how much of an error response is kept.
+const maxErrorBody = 64 * 1024
+
+// APIError is the error object a backend puts in a failed response body.
+// Groq and OpenAI send {"error": {"message", "type", "code"}}, Anthropic
+// {"type": "error", "error": {"type", "message"}} and Ollama
+// {"error": "message"}.
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Param   string `json:"param"`
+}
+
+// decodeAPIError extracts the error object from body, or returns nil when
+// body is not in a known format.
+func decodeAPIError(body []byte) *APIError {
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || len(envelope.Error) == 0 {
+		return nil
+	}
+
+	var message string
+	if err := json.Unmarshal(envelope.Error, &message); err == nil {
+		return &APIError{Message: message}
+	}
+
+	// code is a string for Groq but may be null or a number elsewhere
+	var detail struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+		Param   interface{} `json:"param"`
+	}
+	if err := json.Unmarshal(envelope.Error, &detail); err != nil || detail.Message == "" {
+		return nil
+	}
+	apiErr := &APIError{Message: detail.Message, Type: detail.Type}
+	if detail.Code != nil {
+		apiErr.Code = fmt.Sprint(detail.Code)
+	}
+	if detail.Param != nil {
+		apiErr.Param = fmt.Sprint(detail.Param)
+	}
+	return apiErr
+}
+
+// StatusError is returned when a backend answers with a non-2xx status or
+// reports an error in the middle of a stream.
+type StatusError struct {
+	// StatusCode is zero for errors reported inside a stream.
+	StatusCode int
+	// Kind is the failure class, one of the Err* variables above.
+	Kind error
+	// API is the decoded error body, nil if it could not be decoded.
+	API *APIError
+	// RequestID identifies the request in the backend's logs.
+	RequestID string
+	// RetryAfter is how long the backend asked us to wait, zero if it
+	// did not say.
+	RetryAfter time.Duration
+	// Body is the (truncated) response body.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+
+	var meta []string
+	if e.StatusCode != 0 {
+		meta = append(meta, fmt.Sprintf("HTTP %d", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		meta = append(meta, "request "+e.RequestID)
+	}
+	if len(meta) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(meta, ", "))
+	}
+
+	if e.API != nil {
+		b.WriteString(": " + e.API.Message)
+	} else if body := strings.TrimSpace(e.Body); body != "" {
+		b.WriteString(": " + body)
+	}
+	return b.String()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Kind
+}
+
+// newStatusError consumes the body of a failed response.
+func newStatusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &StatusError{
+		StatusCode: resp.StatusCode,
+		API:        decodeAPIError(body),
+		RequestID:  requestID(resp.Header),
+		RetryAfter: retryAfter(resp.Header, time.Now()),
+		Body:       string(body),
+	}
+	e.Kind = classify(resp.StatusCode, e.API, e.Body)
+	return e
+}
+
+// streamError wraps an error a backend reported inside a stream or an
+// otherwise successful response.
+func streamError(apiErr *APIError, header http.Header) *StatusError {
+	return &StatusError{
+		Kind:      classify(0, apiErr, apiErr.Message),
+		API:       apiErr,
+		RequestID: requestID(header),
+	}
+}
+
+// requestID returns the request identifier Groq and OpenAI (x-request-id)
+// or Anthropic (request-id) attach to responses.
+func requestID(header http.Header) string {
+	if id := header.Get("x-request-id"); id != "" {
+		return id
+	}
+	return header.Get("request-id")
+}
+
+func classify(status int, apiErr *APIError, body string) error {
+	if apiErr != nil {
+		switch apiErr.Code {
+		case "context_length_exceeded":
+			return ErrContextLength
+		case "rate_limit_exceeded":
+			return ErrRateLimited
+		case "invalid_api_key":
+			return ErrAuth
+		case "model_decommissioned", "model_not_found":
+			return ErrModelUnavailable
+		}
+		switch apiErr.Type {
+		case "rate_limit_error":
+			return ErrRateLimited
+		case "authentication_error", "permission_error":
+			return ErrAuth
+		case "overloaded_error", "api_error":
+			return ErrServer
+		}
+	}
+
+	lower := strings.ToLower(body)
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusNotFound:
+		// Ollama and OpenAI answer 404 for unknown models
+		return ErrModelUnavailable
+	case status == http.StatusRequestEntityTooLarge,
+		strings.Contains(lower, "context_length_exceeded"),
+		strings.Contains(lower, "context length"),
+		strings.Contains(lower, "context window"):
+		return ErrContextLength
+	case status >= 500:
+		// Anthropic reports overload as 529
+		return ErrServer
+	case status == 0:
+		// Errors inside an otherwise successful stream
+		return ErrServer
+	default:
+		return ErrBadRequest
+	}
+}
+
+// UserMessage turns an error from Chat or ChatStream into a short message
+// suitable for showing to end users.
+func UserMessage(err error) string {
+	var statusErr *StatusError
+	errors.As(err, &statusErr)
+
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		if statusErr != nil && statusErr.RetryAfter > 0 {
+			return fmt.Sprintf("The AI service is rate limiting requests. Try again in %s.", statusErr.RetryAfter.Round(time.Second))
+		}
+		return "The AI service is rate limiting requests. Wait a moment and try again."
+	case errors.Is(err, ErrAuth):
+		return "The AI service rejected the API key. Check the key configured for this provider."
+	case errors.Is(err, ErrContextLength):
+		return "The conversation is too long for this model. Start a new chat or send a shorter message."
+	case errors.Is(err, ErrServer):
+		return "The AI service is having problems right now. Try again later."
+	case errors.Is(err, ErrModelUnavailable):
+		return "The selected model is not available."
+	case errors.Is(err, ErrBadRequest):
+		if statusErr != nil && statusErr.API != nil {
+			return "The AI service rejected the request: " + statusErr.API.Message
+		}
+		return "The AI service rejected the request."
+	case errors.Is(err, ErrUnknownProvider):
+		return "The selected provider is not configured."
+	case errors.Is(err, ErrNoChoices):
+		return "The AI service returned an empty answer. Try again."
+	case errors.Is(err, context.DeadlineExceeded):
+		return "The AI service took too long to answer."
+	case errors.Is(err, context.Canceled):
+		return "The request was cancelled."
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return "Could not reach the AI service. Check your network connection."
+	}
+	return "Something went wrong while talking to the AI service."
+}
+
+// RequestID returns the backend request ID carried by err, if any.
+func RequestID(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RequestID
+	}
+	return ""
+}
+
+// retryAfter reads the wait requested by Retry-After (seconds or an HTTP
+// date) or, failing that, the x-ratelimit-reset-* headers Groq and OpenAI
+// send with 429 responses.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if at, err := http.ParseTime(value); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+
+	// Wait for whichever exhausted limit resets last
+	var wait time.Duration
+	for _, kind := range []string{"requests", "tokens"} {
+		if header.Get("x-ratelimit-remaining-"+kind) != "0" {
+			continue
+		}
+		if d, err := time.ParseDuration(header.Get("x-ratelimit-reset-" + kind)); err == nil && d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
@@ -0,0 +1,106 @@
+// Package llm is the shared chat-completion client used by the CLI, GUI and
+// web front-ends.
+package llm
+
+import "context"
+
+// Message is a single role-tagged chat message.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Model, Provider and Usage record which backend wrote an assistant
+	// reply and what it took. They are never sent upstream.
+	Model    string `json:"model,omitempty" bson:"model,omitempty"`
+	Provider string `json:"provider,omitempty" bson:"provider,omitempty"`
+	Usage    *Usage `json:"usage,omitempty" bson:"usage,omitempty"`
+}
+
+// Message roles understood by the chat completions API.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Target names a backend and model to send a request to. An empty Model
+// means the provider's default model.
+type Target struct {
+	Provider string
+	Model    string
+}
+
+func (t Target) String() string {
+	if t.Model == "" {
+		return t.Provider
+	}
+	return t.Provider + ":" + t.Model
+}
+
+// Options are per-call overrides of the client configuration.
+type Options struct {
+	// Provider selects a configured backend by name; the default backend
+	// is used when empty.
+	Provider string
+	// Model overrides the provider's default model when non-empty.
+	Model string
+	// Params override the client's default sampling parameters.
+	Params Params
+}
+
+// Response is the assistant reply to a Chat call.
+type Response struct {
+	Message Message
+	Model   string
+	// Provider is the name of the backend that answered.
+	Provider string
+	// Usage is zero when the endpoint did not report token counts.
+	Usage Usage
+	// Truncated is the number of history messages left out of the request
+	// to fit the model's context window.
+	Truncated int
+	// FinishReason tells why the model stopped, in OpenAI's terms:
+	// FinishStop, FinishLength or another reason the backend reported.
+	// It is empty when the backend did not say.
+	FinishReason string
+}
+
+// Finish reasons of a Response.
+const (
+	FinishStop = "stop"
+	// FinishLength means the answer was cut off by the token limit.
+	FinishLength = "length"
+)
+
+// Usage is the token accounting reported for one completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens" bson:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens" bson:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens" bson:"total_tokens"`
+}
+
+// Add returns the sum of u and other.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// DeltaFunc receives each content fragment of a streamed reply in order.
+// Returning an error aborts the stream.
+type DeltaFunc func(delta string) error
+
+// Request is a fully resolved chat request handed to a Provider.
+type Request struct {
+	Messages []Message
+	Model    string
+	Params   Params
+}
+
+// Provider adapts one backend's wire format. Implementations must be safe
+// for concurrent use.
+type Provider interface {
+	Chat(ctx context.Context, req Request) (*Response, error)
+	ChatStream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error)
+}
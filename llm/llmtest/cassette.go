@@ -0,0 +1,204 @@
+package llmtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"askgo/llm"
+)
+
+// Redacted replaces the value of credential headers in recorded fixtures.
+const Redacted = "REDACTED"
+
+// sensitiveHeaders are scrubbed from recorded requests and responses.
+var sensitiveHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// ErrNoInteraction is returned by a Replayer for a request its cassette has
+// no unused interaction for. It wraps llm.ErrPermanent, so the client fails
+// at once instead of retrying.
+var ErrNoInteraction = fmt.Errorf("llmtest: no recorded interaction: %w", llm.ErrPermanent)
+
+// Cassette is the JSON fixture format of recorded HTTP exchanges.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// LoadCassette reads a fixture written by a Recorder.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("llmtest: parse cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to path as indented JSON.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// scrub returns a copy of header with the credentials replaced.
+func scrub(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		key := http.CanonicalHeaderKey(name)
+		if len(header[key]) > 0 {
+			header[key] = []string{Redacted}
+		}
+	}
+	return header
+}
+
+// readBody drains body and returns its contents plus a fresh reader over
+// them.
+func readBody(body io.ReadCloser) (string, io.ReadCloser, error) {
+	if body == nil {
+		return "", http.NoBody, nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Recorder is an http.RoundTripper that forwards requests to Transport and
+// appends every exchange to a cassette file, with credential headers
+// scrubbed. Response bodies are read in full before they are returned, so
+// streamed replies arrive all at once while recording.
+type Recorder struct {
+	// Transport performs the real requests; http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder writing to path after every exchange.
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	return &Recorder{Transport: transport, path: path}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	reqBody, body, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = body
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: scrub(req.Header),
+			Body:   reqBody,
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     scrub(resp.Header),
+			Body:       respBody,
+		},
+	})
+	if err := r.cassette.Save(r.path); err != nil {
+		return nil, fmt.Errorf("llmtest: save cassette: %w", err)
+	}
+	return resp, nil
+}
+
+// Replayer is an http.RoundTripper answering from a cassette without
+// touching the network. Each recorded interaction is used once, matched on
+// method, URL and request body.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer loads the cassette at path.
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: cassette, used: make([]bool, len(cassette.Interactions))}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, _, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		recorded := interaction.Request
+		if r.used[i] || recorded.Method != req.Method || recorded.URL != req.URL.String() || recorded.Body != reqBody {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, req.Method, req.URL)
+}
@@ -0,0 +1,182 @@
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"askgo/llm"
+)
+
+// completion answers an OpenAI chat completion request with content.
+func completion(content string) string {
+	return fmt.Sprintf(`{"id":"x","model":"test-model","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, content)
+}
+
+// cassetteClient returns a client for an OpenAI-compatible backend at
+// baseURL using transport, with quick retries.
+func cassetteClient(baseURL string, transport http.RoundTripper) *llm.Client {
+	return llm.NewClient(llm.Config{
+		Provider:  llm.ProviderOpenAI,
+		BaseURL:   baseURL,
+		APIKey:    "sk-secret",
+		Model:     "test-model",
+		Transport: transport,
+		Retry:     llm.RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+}
+
+func ask(client *llm.Client, prompt string) (*llm.Response, error) {
+	return client.Chat(context.Background(), []llm.Message{{Role: llm.RoleUser, Content: prompt}}, llm.Options{})
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "server-secret"})
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "chat/completions") {
+			fmt.Fprint(w, completion("recorded answer"))
+		}
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	resp, err := ask(cassetteClient(server.URL, NewRecorder(path, nil)), "hello")
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if resp.Message.Content != "recorded answer" {
+		t.Errorf("recorded answer = %q", resp.Message.Content)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"sk-secret", "server-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 1 {
+		t.Fatalf("recorded %d interactions, want 1", len(cassette.Interactions))
+	}
+	recorded := cassette.Interactions[0]
+	if got := recorded.Request.Header.Get("Authorization"); got != Redacted {
+		t.Errorf("recorded Authorization = %q, want %q", got, Redacted)
+	}
+	if got := recorded.Response.Header.Get("Set-Cookie"); got != Redacted {
+		t.Errorf("recorded Set-Cookie = %q, want %q", got, Redacted)
+	}
+
+	// The server is gone; the answer now comes from the cassette
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := cassetteClient(server.URL, replayer)
+	resp, err = ask(client, "hello")
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if resp.Message.Content != "recorded answer" || resp.Usage.TotalTokens != 5 {
+		t.Errorf("replayed %+v, want the recorded answer and usage", resp)
+	}
+
+	// Each interaction is used once
+	if _, err := ask(client, "hello"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("second replay error = %v, want ErrNoInteraction", err)
+	}
+}
+
+// countingTransport counts the requests passed on to a Replayer.
+type countingTransport struct {
+	n    atomic.Int32
+	next http.RoundTripper
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return c.next.RoundTrip(req)
+}
+
+// A request missing from the cassette fails at once instead of being
+// retried like a network error.
+func TestReplayMissNotRetried(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.json")
+	if err := (&Cassette{}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countingTransport{next: replayer}
+
+	_, err = ask(cassetteClient("http://fixture.invalid", transport), "not recorded")
+	if !errors.Is(err, ErrNoInteraction) || !errors.Is(err, llm.ErrPermanent) {
+		t.Errorf("error = %v, want ErrNoInteraction", err)
+	}
+	if n := transport.n.Load(); n != 1 {
+		t.Errorf("made %d attempts, want 1", n)
+	}
+}
+
+func TestReplayMatchesBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette := &Cassette{}
+	for _, prompt := range []string{"first", "second"} {
+		cassette.Interactions = append(cassette.Interactions, Interaction{
+			Request: RecordedRequest{
+				Method: http.MethodPost,
+				URL:    "http://fixture.invalid/chat/completions",
+				Body:   fmt.Sprintf(`{"model":"test-model","messages":[{"role":"user","content":%q}]}`, prompt),
+			},
+			Response: RecordedResponse{StatusCode: http.StatusOK, Body: completion("answer to " + prompt)},
+		})
+	}
+	if err := cassette.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prompt := range []string{"second", "first"} {
+		req, _ := http.NewRequest(http.MethodPost, "http://fixture.invalid/chat/completions",
+			strings.NewReader(fmt.Sprintf(`{"model":"test-model","messages":[{"role":"user","content":%q}]}`, prompt)))
+		resp, err := replayer.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip(%s): %v", prompt, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength != int64(len(completion("answer to "+prompt))) {
+			t.Errorf("RoundTrip(%s) replayed the wrong interaction", prompt)
+		}
+	}
+}
+
+func TestLoadCassetteErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadCassette(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing cassette error = %v", err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte("{"), 0644)
+	if _, err := LoadCassette(bad); err == nil || !strings.Contains(err.Error(), "parse cassette") {
+		t.Errorf("malformed cassette error = %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package llmtest provides offline stand-ins for chat backends: a scripted
+// fake provider and an HTTP transport that records real exchanges to JSON
+// fixtures and replays them later.
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"askgo/llm"
+)
+
+// FakeName is the provider name NewClient registers the fake under.
+const FakeName = "fake"
+
+// FakeModel is the model a Reply reports when it does not set one.
+const FakeModel = "fake-model"
+
+// ErrScriptExhausted is returned once every scripted reply has been used.
+var ErrScriptExhausted = errors.New("llmtest: no scripted reply left")
+
+// Reply scripts one answer of a FakeProvider.
+type Reply struct {
+	// Content is the assistant message.
+	Content string
+	// Chunks splits a streamed reply; Content is split into words when nil.
+	Chunks []string
+	// Err is returned instead of a reply.
+	Err error
+	// FailAfter makes a stream fail with Err after that many chunks.
+	FailAfter int
+	// Delay is waited before each streamed chunk.
+	Delay time.Duration
+	Model string
+	Usage llm.Usage
+	// FinishReason is reported with the reply, llm.FinishStop when empty.
+	FinishReason string
+}
+
+// FakeProvider is an in-process llm.Provider answering from a script.
+type FakeProvider struct {
+	mu       sync.Mutex
+	replies  []Reply
+	requests []llm.Request
+}
+
+// NewFakeProvider returns a provider that answers with replies in order.
+func NewFakeProvider(replies ...Reply) *FakeProvider {
+	return &FakeProvider{replies: replies}
+}
+
+// NewClient returns an llm.Client whose default backend is f.
+func NewClient(f *FakeProvider) *llm.Client {
+	client := llm.NewClient(llm.Config{Provider: FakeName})
+	client.RegisterProvider(FakeName, f, FakeModel)
+	return client
+}
+
+// Push appends replies to the script.
+func (f *FakeProvider) Push(replies ...Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// Requests returns every request received so far.
+func (f *FakeProvider) Requests() []llm.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := make([]llm.Request, len(f.requests))
+	for i, req := range f.requests {
+		req.Messages = append([]llm.Message(nil), req.Messages...)
+		requests[i] = req
+	}
+	return requests
+}
+
+func (f *FakeProvider) next(req llm.Request) (Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := append([]llm.Message(nil), req.Messages...)
+	req.Messages = messages
+	f.requests = append(f.requests, req)
+
+	if len(f.replies) == 0 {
+		return Reply{}, ErrScriptExhausted
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	return reply, nil
+}
+
+func (r Reply) response(req llm.Request, content string) *llm.Response {
+	model := r.Model
+	if model == "" {
+		model = req.Model
+	}
+	finishReason := r.FinishReason
+	if finishReason == "" {
+		finishReason = llm.FinishStop
+	}
+	return &llm.Response{
+		Message:      llm.Message{Role: llm.RoleAssistant, Content: content},
+		Model:        model,
+		Usage:        r.Usage,
+		FinishReason: finishReason,
+	}
+}
+
+func (r Reply) chunks() []string {
+	if r.Chunks != nil {
+		return r.Chunks
+	}
+	words := strings.SplitAfter(r.Content, " ")
+	if len(words) == 1 && words[0] == "" {
+		return nil
+	}
+	return words
+}
+
+func (f *FakeProvider) Chat(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	reply, err := f.next(req)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return reply.response(req, reply.Content), nil
+}
+
+func (f *FakeProvider) ChatStream(ctx context.Context, req llm.Request, onDelta llm.DeltaFunc) (*llm.Response, error) {
+	reply, err := f.next(req)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Err != nil && reply.FailAfter == 0 {
+		return nil, reply.Err
+	}
+
+	var content strings.Builder
+	for i, chunk := range reply.chunks() {
+		if reply.Err != nil && i == reply.FailAfter {
+			return nil, reply.Err
+		}
+		if reply.Delay > 0 {
+			select {
+			case <-time.After(reply.Delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		content.WriteString(chunk)
+		if onDelta != nil {
+			if err := onDelta(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+	return reply.response(req, content.String()), nil
+}
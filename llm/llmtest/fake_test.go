@@ -0,0 +1,128 @@
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"askgo/llm"
+)
+
+var errBoom = errors.New("boom")
+
+func user(content string) []llm.Message {
+	return []llm.Message{{Role: llm.RoleUser, Content: content}}
+}
+
+func TestFakeChat(t *testing.T) {
+	fake := NewFakeProvider(
+		Reply{Content: "first", Usage: llm.Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2}},
+		Reply{Err: errBoom},
+	)
+	client := NewClient(fake)
+
+	resp, err := client.Chat(context.Background(), user("hi"), llm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message.Content != "first" || resp.Message.Role != llm.RoleAssistant {
+		t.Errorf("message = %+v, want the first scripted reply", resp.Message)
+	}
+	if resp.Model != FakeModel || resp.Usage.TotalTokens != 2 {
+		t.Errorf("model %q usage %+v, want %q and the scripted usage", resp.Model, resp.Usage, FakeModel)
+	}
+
+	if _, err := client.Chat(context.Background(), user("again"), llm.Options{}); !errors.Is(err, errBoom) {
+		t.Errorf("second reply err = %v, want the scripted error", err)
+	}
+	if _, err := client.Chat(context.Background(), user("more"), llm.Options{}); !errors.Is(err, ErrScriptExhausted) {
+		t.Errorf("exhausted script err = %v, want ErrScriptExhausted", err)
+	}
+
+	fake.Push(Reply{Content: "pushed", Model: "other"})
+	resp, err = client.Chat(context.Background(), user("last"), llm.Options{})
+	if err != nil || resp.Message.Content != "pushed" || resp.Model != "other" {
+		t.Errorf("pushed reply = %+v, %v", resp, err)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 4 || requests[0].Messages[0].Content != "hi" || requests[3].Model != FakeModel {
+		t.Fatalf("requests = %+v, want all four with the default model", requests)
+	}
+	// Requests hands out copies
+	requests[0].Messages[0].Content = "changed"
+	if fake.Requests()[0].Messages[0].Content != "hi" {
+		t.Error("changing a returned request changed the recorded one")
+	}
+}
+
+func TestFakeChatStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  Reply
+		deltas []string
+		want   string
+		err    error
+	}{
+		{"words", Reply{Content: "one two three"}, []string{"one ", "two ", "three"}, "one two three", nil},
+		{"chunks", Reply{Chunks: []string{"on", "e t", "wo"}}, []string{"on", "e t", "wo"}, "one two", nil},
+		{"empty", Reply{}, nil, "", nil},
+		{"fails at once", Reply{Content: "one two", Err: errBoom}, nil, "", errBoom},
+		{"fails midway", Reply{Content: "one two three", Err: errBoom, FailAfter: 2}, []string{"one ", "two "}, "", errBoom},
+		{"fails at the end", Reply{Content: "one two", Err: errBoom, FailAfter: 5}, []string{"one ", "two"}, "", errBoom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(NewFakeProvider(tt.reply))
+			var deltas []string
+			resp, err := client.ChatStream(context.Background(), user("hi"), llm.Options{}, func(delta string) error {
+				deltas = append(deltas, delta)
+				return nil
+			})
+			if strings.Join(deltas, "|") != strings.Join(tt.deltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.deltas)
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Message.Content != tt.want {
+				t.Errorf("content = %q, want %q", resp.Message.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeChatStreamDelay(t *testing.T) {
+	client := NewClient(NewFakeProvider(Reply{Content: "slow reply here", Delay: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.ChatStream(ctx, user("hi"), llm.Options{}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("cancelled stream returned after %v", elapsed)
+	}
+}
+
+func TestFakeChatStreamStoppedByCallback(t *testing.T) {
+	client := NewClient(NewFakeProvider(Reply{Content: "one two three"}))
+	calls := 0
+	_, err := client.ChatStream(context.Background(), user("hi"), llm.Options{}, func(string) error {
+		calls++
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) || calls != 1 {
+		t.Errorf("err = %v after %d deltas, want the callback's error after one", err, calls)
+	}
+}
@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelLister is implemented by providers that can list the models they
+// serve.
+type ModelLister interface {
+	Models(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ModelInfo describes one model of a backend.
+type ModelInfo struct {
+	ID string
+	// ContextWindow is the model's context length in tokens, zero when
+	// the backend does not report it.
+	ContextWindow int
+}
+
+// DefaultContextWindow is assumed for models of unknown context length.
+const DefaultContextWindow = 8192
+
+// contextWindows maps model name prefixes to their context length, for
+// backends that do not report it. The longest matching prefix wins.
+var contextWindows = map[string]int{
+	"llama3-":            8192,
+	"llama-3.1-":         131072,
+	"llama-3.3-":         131072,
+	"llama3.1":           131072,
+	"llama3.2":           131072,
+	"llama3.3":           131072,
+	"llama2":             4096,
+	"mixtral-8x7b-32768": 32768,
+	"mistral":            32768,
+	"gemma":              8192,
+	"gpt-4o":             128000,
+	"gpt-4-turbo":        128000,
+	"gpt-4":              8192,
+	"gpt-3.5-turbo":      16385,
+	"claude-":            200000,
+}
+
+// Model lists are cached for modelCacheTTL, failures for modelErrorTTL so an
+// unreachable backend is not asked again on every call.
+const (
+	modelCacheTTL = 10 * time.Minute
+	modelErrorTTL = time.Minute
+)
+
+// modelCache holds the model list of each provider.
+type modelCache struct {
+	mu      sync.Mutex
+	entries map[string]modelCacheEntry
+}
+
+type modelCacheEntry struct {
+	models []string
+	// windows holds the context lengths the backend reported
+	windows map[string]int
+	err     error
+	expires time.Time
+}
+
+// Models returns the models served by provider, or by the default backend
+// when provider is empty, sorted. The list is fetched from the backend and
+// cached; backends that cannot list models report only their default model.
+func (c *Client) Models(ctx context.Context, provider string) ([]string, error) {
+	if provider == "" {
+		provider = c.provider
+	}
+	p, ok := c.providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, provider)
+	}
+	lister, ok := p.(ModelLister)
+	if !ok {
+		return []string{c.models[provider]}, nil
+	}
+
+	c.modelCache.mu.Lock()
+	entry, ok := c.modelCache.entries[provider]
+	c.modelCache.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return append([]string(nil), entry.models...), entry.err
+	}
+
+	infos, err := lister.Models(ctx)
+	entry = modelCacheEntry{expires: time.Now().Add(modelCacheTTL), windows: make(map[string]int)}
+	if err != nil {
+		entry.err = fmt.Errorf("llm: list %s models: %w", provider, err)
+		entry.expires = time.Now().Add(modelErrorTTL)
+	}
+	for _, info := range infos {
+		entry.models = append(entry.models, info.ID)
+		if info.ContextWindow > 0 {
+			entry.windows[info.ID] = info.ContextWindow
+		}
+	}
+	sort.Strings(entry.models)
+	// A cancelled caller says nothing about the backend
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		c.modelCache.mu.Lock()
+		c.modelCache.entries[provider] = entry
+		c.modelCache.mu.Unlock()
+	}
+	return append([]string(nil), entry.models...), entry.err
+}
+
+// ContextWindow returns the context length of model on provider, in
+// tokens: the configured value, else what the backend reported in its
+// (already fetched) model list, else the built-in table, else
+// DefaultContextWindow.
+func (c *Client) ContextWindow(provider, model string) int {
+	if n := c.contextWindows[model]; n > 0 {
+		return n
+	}
+
+	c.modelCache.mu.Lock()
+	n := c.modelCache.entries[provider].windows[model]
+	c.modelCache.mu.Unlock()
+	if n > 0 {
+		return n
+	}
+
+	best := ""
+	for prefix := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return contextWindows[best]
+	}
+	return DefaultContextWindow
+}
+
+// CheckModel reports whether t names a configured backend that serves
+// t.Model. Models of backends whose list cannot be fetched are accepted.
+func (c *Client) CheckModel(ctx context.Context, t Target) error {
+	models, err := c.Models(ctx, t.Provider)
+	if errors.Is(err, ErrUnknownProvider) {
+		return err
+	}
+	if err != nil || t.Model == "" {
+		return nil
+	}
+	for _, model := range models {
+		if model == t.Model {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrModelUnavailable, t.Model)
+}
+
+// Catalog returns the models of every configured backend, ordered by
+// provider name and model. Backends whose list cannot be fetched are left
+// out and their errors joined into the returned error.
+func (c *Client) Catalog(ctx context.Context) ([]Target, error) {
+	var catalog []Target
+	var errs []error
+	for _, name := range c.Providers() {
+		models, err := c.Models(ctx, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, model := range models {
+			catalog = append(catalog, Target{Provider: name, Model: model})
+		}
+	}
+	return catalog, errors.Join(errs...)
+}
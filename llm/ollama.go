@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ollamaProvider talks to a local Ollama server through its native
+// /api/chat endpoint.
+type ollamaProvider struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+type ollamaRequest struct {
+	Model    string         `json:"model"`
+	Messages []wireMessage  `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  *ollamaOptions `json:"options,omitempty"`
+}
+
+// ollamaOptions are the sampling parameters under Ollama's names.
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+}
+
+// ollamaResponse is both the non-streamed reply and each line of a stream.
+type ollamaResponse struct {
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	Error           string  `json:"error"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+func (r ollamaResponse) usage() Usage {
+	return Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.send(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("llm: read response: %w", err)
+	}
+
+	var reply ollamaResponse
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("llm: parse response: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, streamError(&APIError{Message: reply.Error}, resp.Header)
+	}
+
+	return &Response{Message: reply.Message, Model: reply.Model, Usage: reply.usage(), FinishReason: reply.DoneReason}, nil
+}
+
+// ChatStream reads Ollama's newline-delimited JSON stream.
+func (p *ollamaProvider) ChatStream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	resp, err := p.send(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var last ollamaResponse
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("llm: parse stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, streamError(&APIError{Message: chunk.Error}, resp.Header)
+		}
+		last = chunk
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				if err := onDelta(chunk.Message.Content); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("llm: read stream: %w", err)
+	}
+	if !last.Done {
+		return nil, ErrNoChoices
+	}
+
+	return &Response{
+		Message:      Message{Role: RoleAssistant, Content: content.String()},
+		Model:        last.Model,
+		Usage:        last.usage(),
+		FinishReason: last.DoneReason,
+	}, nil
+}
+
+// Models lists the models pulled into the local Ollama server.
+func (p *ollamaProvider) Models(ctx context.Context) ([]ModelInfo, error) {
+	var list struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getJSON(ctx, p.httpClient, p.baseURL+"/api/tags", nil, &list); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, len(list.Models))
+	for i, m := range list.Models {
+		models[i] = ModelInfo{ID: m.Name}
+	}
+	return models, nil
+}
+
+func (p *ollamaProvider) send(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	body := ollamaRequest{Model: req.Model, Messages: toWire(req.Messages), Stream: stream}
+	if p := req.Params; !p.IsZero() {
+		body.Options = &ollamaOptions{
+			Temperature: p.Temperature,
+			TopP:        p.TopP,
+			NumPredict:  p.MaxTokens,
+			Stop:        p.Stop,
+			Seed:        p.Seed,
+		}
+	}
+	return postJSON(ctx, p.httpClient, p.baseURL+"/api/chat", nil, body, false)
+}
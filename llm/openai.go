@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAIProvider speaks the OpenAI chat completions API, which Groq and
+// many other hosted and self-hosted servers also implement.
+type openAIProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+type chatRequest struct {
+	Messages []wireMessage `json:"messages"`
+	Model    string        `json:"model"`
+	Stream   bool          `json:"stream,omitempty"`
+	// The sampling parameters use the OpenAI names already
+	Params
+}
+
+type chatResponse struct {
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+type choice struct {
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type chatChunk struct {
+	Model   string        `json:"model"`
+	Choices []chunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage"`
+	// Groq reports usage of a stream in its own field of the last chunk.
+	XGroq struct {
+		Usage *Usage `json:"usage"`
+	} `json:"x_groq"`
+}
+
+type chunkChoice struct {
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.send(ctx, chatRequest{Messages: toWire(req.Messages), Model: req.Model, Params: req.Params})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("llm: read response: %w", err)
+	}
+
+	var completion chatResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return nil, fmt.Errorf("llm: parse response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, ErrNoChoices
+	}
+
+	return &Response{
+		Message:      completion.Choices[0].Message,
+		Model:        completion.Model,
+		Usage:        completion.Usage,
+		FinishReason: completion.Choices[0].FinishReason,
+	}, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	resp, err := p.send(ctx, chatRequest{Messages: toWire(req.Messages), Model: req.Model, Stream: true, Params: req.Params})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var usage Usage
+	model, finishReason := "", ""
+	received := false
+	err = readEvents(resp.Body, func(data string) error {
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("llm: parse stream chunk: %w", err)
+		}
+		if apiErr := decodeAPIError([]byte(data)); apiErr != nil {
+			return streamError(apiErr, resp.Header)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		} else if chunk.XGroq.Usage != nil {
+			usage = *chunk.XGroq.Usage
+		}
+		for _, choice := range chunk.Choices {
+			received = true
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if onDelta != nil {
+				if err := onDelta(choice.Delta.Content); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !received {
+		return nil, ErrNoChoices
+	}
+
+	return &Response{
+		Message:      Message{Role: RoleAssistant, Content: content.String()},
+		Model:        model,
+		Usage:        usage,
+		FinishReason: finishReason,
+	}, nil
+}
+
+// Models lists the models served by the endpoint. Groq also reports their
+// context length.
+func (p *openAIProvider) Models(ctx context.Context) ([]ModelInfo, error) {
+	header := http.Header{}
+	if p.apiKey != "" {
+		header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextWindow int    `json:"context_window"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, p.httpClient, p.baseURL+"/models", header, &list); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, len(list.Data))
+	for i, m := range list.Data {
+		models[i] = ModelInfo{ID: m.ID, ContextWindow: m.ContextWindow}
+	}
+	return models, nil
+}
+
+// send posts body to the completions endpoint. The caller closes the
+// response body.
+func (p *openAIProvider) send(ctx context.Context, body chatRequest) (*http.Response, error) {
+	header := http.Header{}
+	if p.apiKey != "" {
+		header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	return postJSON(ctx, p.httpClient, p.baseURL+"/chat/completions", header, body, body.Stream)
+}
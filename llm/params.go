@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Params are the sampling parameters of a request. Nil fields and an empty
+// Stop leave the backend's default in place.
+type Params struct {
+	Temperature *float64 `json:"temperature,omitempty" bson:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty" bson:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" bson:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty" bson:"stop,omitempty"`
+	Seed        *int64   `json:"seed,omitempty" bson:"seed,omitempty"`
+}
+
+// ParamNames lists the names accepted by Params.Set.
+var ParamNames = []string{"temperature", "top_p", "max_tokens", "stop", "seed"}
+
+// Set parses value into the parameter called name. An empty value or
+// "default" resets the parameter. Stop sequences are separated by commas.
+func (p *Params) Set(name, value string) error {
+	value = strings.TrimSpace(value)
+	reset := value == "" || value == "default"
+
+	switch strings.ReplaceAll(name, "-", "_") {
+	case "temperature":
+		if reset {
+			p.Temperature = nil
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 2 {
+			return fmt.Errorf("temperature must be a number between 0 and 2")
+		}
+		p.Temperature = &f
+	case "top_p":
+		if reset {
+			p.TopP = nil
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 || f > 1 {
+			return fmt.Errorf("top_p must be a number above 0 and at most 1")
+		}
+		p.TopP = &f
+	case "max_tokens":
+		if reset {
+			p.MaxTokens = nil
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("max_tokens must be a positive integer")
+		}
+		p.MaxTokens = &n
+	case "stop":
+		p.Stop = nil
+		if reset {
+			return nil
+		}
+		for _, s := range strings.Split(value, ",") {
+			if s != "" {
+				p.Stop = append(p.Stop, s)
+			}
+		}
+		if len(p.Stop) > 4 {
+			return fmt.Errorf("at most 4 stop sequences are allowed")
+		}
+	case "seed":
+		if reset {
+			p.Seed = nil
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("seed must be an integer")
+		}
+		p.Seed = &n
+	default:
+		return fmt.Errorf("unknown parameter %q (want one of %s)", name, strings.Join(ParamNames, ", "))
+	}
+	return nil
+}
+
+// Get formats the parameter called name, empty when it is unset.
+func (p Params) Get(name string) string {
+	switch strings.ReplaceAll(name, "-", "_") {
+	case "temperature":
+		if p.Temperature != nil {
+			return strconv.FormatFloat(*p.Temperature, 'g', -1, 64)
+		}
+	case "top_p":
+		if p.TopP != nil {
+			return strconv.FormatFloat(*p.TopP, 'g', -1, 64)
+		}
+	case "max_tokens":
+		if p.MaxTokens != nil {
+			return strconv.Itoa(*p.MaxTokens)
+		}
+	case "stop":
+		return strings.Join(p.Stop, ",")
+	case "seed":
+		if p.Seed != nil {
+			return strconv.FormatInt(*p.Seed, 10)
+		}
+	}
+	return ""
+}
+
+// Validate checks the ranges of the set parameters, e.g. after decoding
+// them from JSON.
+func (p Params) Validate() error {
+	check := p
+	for _, name := range ParamNames {
+		if value := p.Get(name); value != "" {
+			if err := check.Set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Merge returns p with every parameter set in override replacing its own.
+func (p Params) Merge(override Params) Params {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		p.MaxTokens = override.MaxTokens
+	}
+	if len(override.Stop) > 0 {
+		p.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	return p
+}
+
+// IsZero reports whether no parameter is set.
+func (p Params) IsZero() bool {
+	return p.Temperature == nil && p.TopP == nil && p.MaxTokens == nil && len(p.Stop) == 0 && p.Seed == nil
+}
+
+// String lists the set parameters as name=value pairs.
+func (p Params) String() string {
+	var parts []string
+	for _, name := range ParamNames {
+		if value := p.Get(name); value != "" {
+			parts = append(parts, name+"="+value)
+		}
+	}
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, " ")
+}
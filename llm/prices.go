@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Price is what a model costs, in US dollars per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// prices are the list prices of hosted models by model name prefix, used
+// unless Config.Prices names the model. The longest matching prefix wins.
+var prices = map[string]Price{
+	"llama3-8b-8192":          {Input: 0.05, Output: 0.08},
+	"llama3-70b-8192":         {Input: 0.59, Output: 0.79},
+	"llama-3.1-8b-instant":    {Input: 0.05, Output: 0.08},
+	"llama-3.3-70b-versatile": {Input: 0.59, Output: 0.79},
+	"mixtral-8x7b-32768":      {Input: 0.24, Output: 0.24},
+	"gemma2-9b-it":            {Input: 0.20, Output: 0.20},
+	"gpt-4o-mini":             {Input: 0.15, Output: 0.60},
+	"gpt-4o":                  {Input: 2.50, Output: 10.00},
+	"gpt-4-turbo":             {Input: 10.00, Output: 30.00},
+	"gpt-3.5-turbo":           {Input: 0.50, Output: 1.50},
+	"claude-3-5-haiku":        {Input: 0.80, Output: 4.00},
+	"claude-3-5-sonnet":       {Input: 3.00, Output: 15.00},
+	"claude-3-haiku":          {Input: 0.25, Output: 1.25},
+	"claude-3-opus":           {Input: 15.00, Output: 75.00},
+}
+
+// ParsePrices parses a comma-separated list of model=input/output pairs,
+// in dollars per million tokens, such as "llama3-8b-8192=0.05/0.08".
+// Malformed entries are ignored.
+func ParsePrices(s string) map[string]Price {
+	table := make(map[string]Price)
+	for _, field := range strings.Split(s, ",") {
+		model, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		input, output, ok := strings.Cut(value, "/")
+		if !ok {
+			continue
+		}
+		in, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		if err != nil || in < 0 {
+			continue
+		}
+		out, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err != nil || out < 0 {
+			continue
+		}
+		table[strings.TrimSpace(model)] = Price{Input: in, Output: out}
+	}
+	return table
+}
+
+// Price returns the price of model on provider and whether it is known.
+// Models served by a local Ollama are free.
+func (c *Client) Price(provider, model string) (Price, bool) {
+	if p, ok := c.prices[model]; ok {
+		return p, true
+	}
+	if provider == ProviderOllama {
+		return Price{}, true
+	}
+
+	best := ""
+	for prefix := range prices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return prices[best], true
+}
+
+// Cost estimates what usage of model on provider cost in US dollars. It
+// reports false for models of unknown price.
+func (c *Client) Cost(provider, model string, usage Usage) (float64, bool) {
+	p, ok := c.Price(provider, model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*p.Input + float64(usage.CompletionTokens)*p.Output) / 1e6, true
+}
@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+// Retry defaults used when a RetryPolicy field is zero.
+const (
+	DefaultMaxRetries  = 3
+	DefaultBaseDelay   = 500 * time.Millisecond
+	DefaultMaxDelay    = 30 * time.Second
+	DefaultRetryBudget = 2 * time.Minute
+)
+
+// RetryPolicy controls how failed upstream calls are retried. Rate limits,
+// server errors and network failures are retried with jittered exponential
+// backoff, waiting at least as long as the backend asks for.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt. A
+	// negative value disables retries.
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// Budget caps the total time spent waiting between attempts.
+	Budget time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = DefaultMaxRetries
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = DefaultBaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+	if p.Budget == 0 {
+		p.Budget = DefaultRetryBudget
+	}
+	return p
+}
+
+// ErrPermanent marks transport errors that another attempt cannot fix,
+// such as a missing test fixture. Transports wrap it to stop retries.
+var ErrPermanent = errors.New("llm: permanent transport failure")
+
+// retryable reports whether err is worth another attempt.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrPermanent) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Kind == ErrRateLimited || statusErr.Kind == ErrServer
+	}
+	// Connection failures surface as *url.Error from http.Client.Do
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff returns the wait before retry number attempt (starting at 0).
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	ceiling := p.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	// Full jitter spreads out clients that failed at the same moment
+	delay := time.Duration(rand.Int63n(int64(ceiling) + 1))
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+	return delay
+}
+
+// retry calls fn until it succeeds, fails permanently or the policy is
+// exhausted. fn reports whether it already produced output, in which case
+// its error is returned as is. A context done while waiting between
+// attempts ends the retries with its error.
+func (p RetryPolicy) retry(ctx context.Context, fn func() (bool, error)) error {
+	if p.MaxRetries < 0 {
+		_, err := fn()
+		return err
+	}
+
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		started, err := fn()
+		if err == nil || started || attempt >= p.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt, err)
+		if waited+delay > p.Budget {
+			return err
+		}
+		waited += delay
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Summary is a running summary of the oldest messages of a conversation,
+// generated by the model itself so long chats keep their context after it
+// no longer fits the window.
+type Summary struct {
+	Content string `json:"content" bson:"content"`
+	// Covers is the number of leading messages the summary stands in for.
+	Covers int `json:"covers" bson:"covers"`
+}
+
+// A conversation is compacted once its history takes more than
+// compactAbove of the prompt budget, folding the oldest turns into the
+// summary until at most compactTo is left. The gap keeps summaries from
+// being regenerated on every turn.
+const (
+	compactAbove = 0.75
+	compactTo    = 0.5
+	// summaryMaxTokens bounds the length of a summary.
+	summaryMaxTokens = 512
+)
+
+const summaryPrompt = "You maintain the running summary of a conversation between a user and an assistant. " +
+	"Merge the earlier summary, if any, and the new messages into one concise summary. " +
+	"Keep the facts, decisions, names, code identifiers and open questions the assistant needs to continue the conversation. " +
+	"Answer with the summary only."
+
+// Apply returns messages with the summarized ones replaced by a system
+// message holding the summary.
+func (s Summary) Apply(messages []Message) []Message {
+	if s.Content == "" || s.Covers <= 0 {
+		return messages
+	}
+	covers := min(s.Covers, len(messages))
+	applied := []Message{{Role: RoleSystem, Content: "Summary of the earlier conversation:\n" + s.Content}}
+	return append(applied, messages[covers:]...)
+}
+
+// Compact folds the oldest turns of messages into s once the history not
+// yet summarized gets close to the context window of the model opts
+// selects. messages are the conversation without system prompts. It
+// returns the new summary with the response that wrote it, for its usage,
+// or s and a nil Response when there is nothing to do.
+func (c *Client) Compact(ctx context.Context, s Summary, messages []Message, opts Options) (Summary, *Response, error) {
+	t := target{name: opts.Provider, req: Request{Model: opts.Model, Params: c.params.Merge(opts.Params)}}
+	if t.name == "" {
+		t.name = c.provider
+	}
+	if t.req.Model == "" {
+		t.req.Model = c.models[t.name]
+	}
+	budget := float64(c.promptBudget(t))
+
+	s.Covers = min(max(s.Covers, 0), len(messages))
+	if float64(CountMessages(s.Apply(messages))) <= budget*compactAbove {
+		return s, nil, nil
+	}
+
+	// Fold whole turns, always leaving the latest exchange in place
+	cut := s.Covers
+	for cut < len(messages)-2 && float64(CountMessages(messages[cut:])) > budget*compactTo {
+		cut++
+		for cut < len(messages)-2 && messages[cut].Role != RoleUser {
+			cut++
+		}
+	}
+	if cut == s.Covers {
+		return s, nil, nil
+	}
+
+	var prompt strings.Builder
+	if s.Content != "" {
+		fmt.Fprintf(&prompt, "Earlier summary:\n%s\n\n", s.Content)
+	}
+	prompt.WriteString("New messages:\n")
+	for _, m := range messages[s.Covers:cut] {
+		fmt.Fprintf(&prompt, "%s: %s\n", m.Role, m.Content)
+	}
+
+	maxTokens := summaryMaxTokens
+	opts.Params.MaxTokens = &maxTokens
+	resp, err := c.Chat(ctx, []Message{
+		{Role: RoleSystem, Content: summaryPrompt},
+		{Role: RoleUser, Content: prompt.String()},
+	}, opts)
+	if err != nil {
+		return s, nil, fmt.Errorf("llm: summarize conversation: %w", err)
+	}
+	return Summary{Content: strings.TrimSpace(resp.Message.Content), Covers: cut}, resp, nil
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Per-message and per-reply overheads of the chat format, in tokens, as
+// documented for OpenAI models and close enough for the others.
+const (
+	messageOverhead = 4
+	replyOverhead   = 3
+)
+
+// CountTokens estimates the number of tokens of s. Words are counted as one
+// token per four characters, other symbols as one token each, which is
+// within a few percent of BPE tokenizers for English text and code.
+func CountTokens(s string) int {
+	tokens, word := 0, 0
+	flush := func() {
+		tokens += (word + 3) / 4
+		word = 0
+	}
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if r > unicode.MaxLatin1 {
+				// CJK and other scripts are roughly one token per rune
+				flush()
+				tokens++
+				continue
+			}
+			word++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// CountMessages estimates the prompt tokens of messages, including the
+// chat format overhead.
+func CountMessages(messages []Message) int {
+	tokens := replyOverhead
+	for _, m := range messages {
+		tokens += messageOverhead + CountTokens(m.Content)
+	}
+	return tokens
+}
+
+// Truncator shortens a conversation that does not fit in the context
+// window. It must keep system messages and the last message.
+type Truncator interface {
+	Truncate(ctx context.Context, messages []Message, budget int) ([]Message, error)
+}
+
+// DropOldest is the default Truncator: it drops the oldest turns after the
+// leading system messages until the rest fits in budget tokens. The result
+// always starts its turns with a user message, as some backends require.
+type DropOldest struct{}
+
+// Truncate implements Truncator.
+func (DropOldest) Truncate(ctx context.Context, messages []Message, budget int) ([]Message, error) {
+	system := 0
+	for system < len(messages) && messages[system].Role == RoleSystem {
+		system++
+	}
+
+	rest := messages[system:]
+	for len(rest) > 1 && CountMessages(messages[:system])+CountMessages(rest)-replyOverhead > budget {
+		rest = rest[1:]
+		for len(rest) > 1 && rest[0].Role != RoleUser {
+			rest = rest[1:]
+		}
+	}
+
+	truncated := make([]Message, 0, system+len(rest))
+	truncated = append(truncated, messages[:system]...)
+	return append(truncated, rest...), nil
+}
@@ -1,166 +1,155 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
 	"github.com/joho/godotenv"
 
+	"askgo/database"
 	"askgo/gui"
+	"askgo/llm"
+	"askgo/persona"
+	"askgo/web"
 )
 
-type GroqRequest struct {
-	Messages []Message `json:"messages"`
-	Model    string    `json:"model"`
-}
+const usage = `Usage: askgo [command] [flags]
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+Commands:
+  chat      Start an interactive chat in the terminal (default)
+  ask       Answer one question, reading piped input from stdin
+  web       Start the web interface
+  gui       Start the desktop GUI
+  models    List the models of the configured providers
+  personas  List the personas available to chat -persona
+  usage     Report token usage and estimated cost
 
-type GroqResponse struct {
-	Choices []Choice `json:"choices"`
-}
-
-type Choice struct {
-	Message Message `json:"message"`
-}
+Run "askgo <command> -h" for the flags of a command.
+`
 
 func main() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		fmt.Println("Error loading .env file")
+	// Load environment variables. The file is optional so that askgo ask
+	// works from any directory, e.g. in git hooks.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Error loading .env file:", err)
 		os.Exit(1)
 	}
 
-	// Parse command line flags
+	// Parse command line flags. -web and -gui predate the subcommands and
+	// are kept for existing scripts.
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nLegacy flags:")
+		flag.PrintDefaults()
+	}
 	saveFlag := flag.Bool("save", false, "Save the conversation to a file")
 	guiFlag := flag.Bool("gui", false, "Start the GUI version")
 	webFlag := flag.Bool("web", false, "Start the web interface")
 	flag.Parse()
 
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		fmt.Println("GROQ_API_KEY environment variable not set")
-		os.Exit(1)
+	command, args := "chat", flag.Args()
+	if *webFlag {
+		command = "web"
+	} else if *guiFlag {
+		command = "gui"
+	} else if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
-	// Initialize color output
-	userColor := color.New(color.FgGreen).SprintFunc()
-	aiColor := color.New(color.FgCyan).SprintFunc()
-
-	// Create a slice to store conversation history
-	var history []string
+	// Each command validates the backend it uses
+	cfg, err := llm.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in environment:", err)
+		os.Exit(1)
+	}
 
-	if *webFlag {
-		startWebServer()
-	} else if *guiFlag {
-		gui.StartGUI()
-	} else {
-		for {
-			fmt.Print(userColor("You: "))
-			reader := bufio.NewReader(os.Stdin)
-			prompt, err := reader.ReadString('\n')
-			if err != nil {
-				fmt.Println("Error reading input:", err)
-				continue
-			}
-			prompt = strings.TrimSpace(prompt)
-
-			if prompt == "exit" || prompt == "quit" {
-				break
-			}
-
-			// Add to history
-			history = append(history, "You: "+prompt)
-
-			// Prepare the request
-			requestBody := GroqRequest{
-				Messages: []Message{
-					{
-						Role:    "user",
-						Content: prompt,
-					},
-				},
-				Model: "llama3-8b-8192",
-			}
-
-			jsonData, err := json.Marshal(requestBody)
-			if err != nil {
-				fmt.Println("Error marshaling request:", err)
-				continue
-			}
-
-			// Create HTTP request
-			req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(jsonData))
-			if err != nil {
-				fmt.Println("Error creating request:", err)
-				continue
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-
-			// Send request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Println("Error sending request:", err)
-				continue
-			}
-			defer resp.Body.Close()
-
-			// Read response
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				fmt.Println("Error reading response:", err)
-				continue
-			}
-
-			// Parse response
-			var groqResponse GroqResponse
-			if err := json.Unmarshal(body, &groqResponse); err != nil {
-				fmt.Println("Error parsing response:", err)
-				continue
-			}
-
-			// Print AI response
-			response := groqResponse.Choices[0].Message.Content
-			fmt.Println(aiColor("AI: " + response))
-
-			// Add to history
-			history = append(history, "AI: "+response)
-
-			// Save conversation if flag is set
-			if *saveFlag {
-				if err := saveConversation(history); err != nil {
-					fmt.Println("Error saving conversation:", err)
-				}
-			}
+	if upstreamTransport != nil {
+		transport, err := upstreamTransport()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
+		cfg.Transport = transport
+	}
+
+	switch command {
+	case "chat":
+		err = runChat(cfg, *saveFlag, args)
+	case "ask":
+		err = runAsk(cfg, args)
+	case "web":
+		err = runWeb(cfg, args)
+	case "gui":
+		err = runGUI(cfg, args)
+	case "models":
+		err = runModels(cfg, args)
+	case "personas":
+		err = runPersonas(args)
+	case "usage":
+		err = runUsage(cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
 
-func saveConversation(history []string) error {
-	file, err := os.OpenFile("conversation.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// upstreamTransport, when set, returns the transport for upstream calls.
+// Builds with the cassette tag set it to record or replay fixtures.
+var upstreamTransport func() (http.RoundTripper, error)
+
+func runWeb(cfg llm.Config, args []string) error {
+	fs := flag.NewFlagSet("web", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	rpm := fs.Int("requests-per-minute", envInt("ASKGO_REQUESTS_PER_MINUTE", web.DefaultLimits.RequestsPerMinute), "Requests each user may send per minute, 0 for no limit")
+	tpd := fs.Int("tokens-per-day", envInt("ASKGO_TOKENS_PER_DAY", web.DefaultLimits.TokensPerDay), "Tokens each user may use per day, 0 for no limit")
+	fs.Parse(args)
+
+	if err := cfg.Validate(""); err != nil {
+		return err
+	}
+	personas, err := persona.Load(persona.Dir())
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return web.Start(web.Config{
+		Addr:     *addr,
+		LLM:      cfg,
+		Personas: personas,
+		Limits:   &database.Limits{RequestsPerMinute: *rpm, TokensPerDay: *tpd},
+		Admins:   strings.Split(os.Getenv("ASKGO_ADMINS"), ","),
+	})
+}
 
-	for _, line := range history {
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			return err
-		}
+// envInt returns the integer in environment variable name, or def when it
+// is unset or malformed.
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
+
+func runGUI(cfg llm.Config, args []string) error {
+	fs := flag.NewFlagSet("gui", flag.ExitOnError)
+	fs.Parse(args)
+
+	if err := cfg.Validate(""); err != nil {
+		return err
+	}
+	personas, err := persona.Load(persona.Dir())
+	if err != nil {
+		return err
 	}
+	gui.StartGUI(cfg, personas)
 	return nil
 }
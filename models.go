@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"askgo/llm"
+	"askgo/repl"
+)
+
+func init() {
+	repl.Register(repl.Command{
+		Name:  "model",
+		Usage: "[name]",
+		Help:  "List the models, or switch to one (provider:model changes backend)",
+		Run: func(s *repl.Session, args string) error {
+			switchModel(s.Client, &s.Options, args, s.Out)
+			return nil
+		},
+		Complete: completeModels,
+	})
+}
+
+// runModels prints the models each configured backend serves.
+func runModels(cfg llm.Config, args []string) error {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	providerFlag := fs.String("provider", "", "Only list the models of this backend")
+	fs.Parse(args)
+
+	client := llm.NewClient(cfg)
+	providers := client.Providers()
+	if *providerFlag != "" {
+		if err := cfg.Validate(*providerFlag); err != nil {
+			return err
+		}
+		providers = []string{*providerFlag}
+	}
+
+	for _, provider := range providers {
+		models, err := client.Models(context.Background(), provider)
+		if err != nil && *providerFlag != "" {
+			return err
+		}
+		printModels(os.Stdout, client, provider, models, client.ProviderModel(provider), err)
+	}
+	return nil
+}
+
+// printModels writes the models of provider with their context length,
+// marking current.
+func printModels(out io.Writer, client *llm.Client, provider string, models []string, current string, err error) {
+	if err != nil {
+		fmt.Fprintf(out, "%s (unavailable: %s)\n", provider, llm.UserMessage(err))
+		return
+	}
+	fmt.Fprintln(out, provider)
+	for _, model := range models {
+		marker := " "
+		if model == current {
+			marker = "*"
+		}
+		fmt.Fprintf(out, "  %s %-40s %7d tokens\n", marker, model, client.ContextWindow(provider, model))
+	}
+}
+
+// completeModels completes a model of the current backend, or a
+// "provider:model" of any other.
+func completeModels(s *repl.Session, arg string) []string {
+	provider := s.Target().Provider
+	var names []string
+	if models, err := s.Client.Models(context.Background(), provider); err == nil {
+		for _, model := range models {
+			if strings.HasPrefix(model, arg) {
+				names = append(names, model)
+			}
+		}
+	}
+	for _, p := range s.Client.Providers() {
+		if p != provider && strings.HasPrefix(p+":", arg) {
+			names = append(names, p+":")
+		}
+		if other, _, ok := strings.Cut(arg, ":"); ok && other == p {
+			models, _ := s.Client.Models(context.Background(), p)
+			for _, model := range models {
+				if strings.HasPrefix(p+":"+model, arg) {
+					names = append(names, p+":"+model)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// switchModel handles "/model [name]" in the chat loop. Without a name it
+// lists the models of the current backend; otherwise it switches opts to
+// name, which may be "provider:model" to change backend as well.
+func switchModel(client *llm.Client, opts *llm.Options, name string, out io.Writer) {
+	provider := opts.Provider
+	if provider == "" {
+		provider = client.Provider()
+	}
+
+	if name == "" {
+		current := opts.Model
+		if current == "" {
+			current = client.ProviderModel(provider)
+		}
+		models, err := client.Models(context.Background(), provider)
+		printModels(out, client, provider, models, current, err)
+		return
+	}
+
+	// Ollama models contain colons too, so only treat the prefix as a
+	// provider when one of that name is configured
+	target := llm.Target{Provider: provider, Model: name}
+	if t := llm.ParseTarget(name); client.ProviderModel(t.Provider) != "" {
+		target = t
+		if target.Model == "" {
+			target.Model = client.ProviderModel(target.Provider)
+		}
+	}
+
+	if err := client.CheckModel(context.Background(), target); err != nil {
+		fmt.Fprintf(out, "Unknown model %q; type /model to list the available ones\n", target.Model)
+		return
+	}
+	opts.Provider, opts.Model = target.Provider, target.Model
+	fmt.Fprintf(out, "Switched to %s\n", target)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"askgo/llm"
+	"askgo/repl"
+)
+
+func init() {
+	repl.Register(repl.Command{
+		Name:  "set",
+		Usage: "[name [value]]",
+		Help:  "Show the generation parameters, or change one",
+		Run: func(s *repl.Session, args string) error {
+			setParam(s.Client, &s.Options.Params, args, s.Out)
+			return nil
+		},
+		Complete: func(_ *repl.Session, arg string) []string {
+			var names []string
+			for _, name := range llm.ParamNames {
+				if strings.HasPrefix(name, arg) {
+					names = append(names, name)
+				}
+			}
+			return names
+		},
+	})
+}
+
+// paramUsage documents the sampling parameters for flags and /set.
+var paramUsage = map[string]string{
+	"temperature": "Sampling temperature between 0 and 2",
+	"top_p":       "Nucleus sampling probability mass",
+	"max_tokens":  "Maximum number of tokens in a reply",
+	"stop":        "Comma-separated stop sequences",
+	"seed":        "Seed for reproducible sampling",
+}
+
+// paramFlags registers a flag for every sampling parameter of params on fs,
+// spelled with dashes, e.g. -top-p.
+func paramFlags(fs *flag.FlagSet, params *llm.Params) {
+	for _, name := range llm.ParamNames {
+		name := name
+		fs.Func(strings.ReplaceAll(name, "_", "-"), paramUsage[name], func(value string) error {
+			return params.Set(name, value)
+		})
+	}
+}
+
+// setParam handles "/set [name [value]]" in the chat loop. Without
+// arguments it shows the effective parameters; without a value it resets
+// the parameter to the default.
+func setParam(client *llm.Client, params *llm.Params, args string, out io.Writer) {
+	name, value, _ := strings.Cut(args, " ")
+	if name == "" {
+		fmt.Fprintln(out, "Parameters:", client.Params().Merge(*params))
+		for _, name := range llm.ParamNames {
+			fmt.Fprintf(out, "  %-12s %s\n", name, paramUsage[name])
+		}
+		return
+	}
+	if err := params.Set(name, value); err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return
+	}
+	fmt.Fprintln(out, "Parameters:", client.Params().Merge(*params))
+}
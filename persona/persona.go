@@ -0,0 +1,159 @@
+// Package persona provides named system prompts with default model and
+// sampling parameters, loaded from a config directory.
+package persona
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"askgo/llm"
+)
+
+// ErrNotFound is returned when no persona has the requested name.
+var ErrNotFound = errors.New("persona not found")
+
+// Persona is a reusable system prompt. Provider, Model and Params are
+// defaults for conversations using it; empty values leave the client's
+// defaults in place.
+type Persona struct {
+	Name        string     `json:"name" bson:"name"`
+	Description string     `json:"description,omitempty" bson:"description,omitempty"`
+	System      string     `json:"system" bson:"system"`
+	Provider    string     `json:"provider,omitempty" bson:"provider,omitempty"`
+	Model       string     `json:"model,omitempty" bson:"model,omitempty"`
+	Params      llm.Params `json:"params,omitempty" bson:"params,omitempty"`
+}
+
+// Builtin are the personas available without any configuration. Files in
+// the persona directory with the same name replace them.
+var Builtin = []Persona{
+	{
+		Name:        "reviewer",
+		Description: "Code reviewer",
+		System: "You are a senior software engineer reviewing code. Point out bugs, " +
+			"race conditions, error handling gaps and unclear naming, most severe " +
+			"first. Quote the lines you comment on and suggest concrete fixes. " +
+			"Do not restate what the code does.",
+	},
+	{
+		Name:        "sql",
+		Description: "SQL helper",
+		System: "You are an expert in SQL and relational databases. Write correct, " +
+			"readable queries, state which dialect you assume, and explain index " +
+			"usage and performance pitfalls briefly. Ask for the schema when it " +
+			"is needed and not given.",
+	},
+	{
+		Name:        "release-notes",
+		Description: "Release-notes writer",
+		System: "You write release notes from commit messages, changelogs or diffs. " +
+			"Group changes under Features, Fixes and Breaking changes, write for " +
+			"users rather than developers, and leave out internal refactorings.",
+	},
+}
+
+// Dir returns the persona directory: $ASKGO_PERSONA_DIR, or "askgo/personas"
+// in the user's config directory.
+func Dir() string {
+	if dir := os.Getenv("ASKGO_PERSONA_DIR"); dir != "" {
+		return dir
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(config, "askgo", "personas")
+}
+
+// Load returns the built-in personas merged with the *.json files in dir,
+// sorted by name. A missing directory is not an error. A file's name is
+// used when its persona does not set one.
+func Load(dir string) ([]Persona, error) {
+	byName := make(map[string]Persona, len(Builtin))
+	for _, p := range Builtin {
+		byName[p.Name] = p
+	}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			p, err := loadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			byName[p.Name] = p
+		}
+	}
+
+	personas := make([]Persona, 0, len(byName))
+	for _, p := range byName {
+		personas = append(personas, p)
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+	return personas, nil
+}
+
+func loadFile(path string) (Persona, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Persona{}, err
+	}
+	var p Persona
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Persona{}, fmt.Errorf("persona %s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := p.Validate(); err != nil {
+		return Persona{}, fmt.Errorf("persona %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Find returns the persona called name.
+func Find(personas []Persona, name string) (Persona, error) {
+	for _, p := range personas {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Persona{}, fmt.Errorf("%w: %q", ErrNotFound, name)
+}
+
+// Validate checks that p has a usable name, a prompt and valid parameters.
+func (p Persona) Validate() error {
+	if p.Name == "" || strings.ContainsAny(p.Name, " /\\:") {
+		return fmt.Errorf("invalid persona name %q", p.Name)
+	}
+	if strings.TrimSpace(p.System) == "" {
+		return errors.New("system prompt is required")
+	}
+	return p.Params.Validate()
+}
+
+// Messages returns messages with the persona's system prompt prepended.
+func (p Persona) Messages(messages []llm.Message) []llm.Message {
+	if p.System == "" {
+		return messages
+	}
+	return append([]llm.Message{{Role: llm.RoleSystem, Content: p.System}}, messages...)
+}
+
+// Options returns opts with the persona's model and parameters filled in
+// where opts leaves them unset.
+func (p Persona) Options(opts llm.Options) llm.Options {
+	if opts.Provider == "" && opts.Model == "" {
+		opts.Provider, opts.Model = p.Provider, p.Model
+	}
+	opts.Params = p.Params.Merge(opts.Params)
+	return opts
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"askgo/persona"
+)
+
+// runPersonas lists the personas available to --persona.
+func runPersonas(args []string) error {
+	fs := flag.NewFlagSet("personas", flag.ExitOnError)
+	fs.Parse(args)
+
+	personas, err := persona.Load(persona.Dir())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Personas (add more as JSON files in " + persona.Dir() + "):")
+	for _, p := range personas {
+		fmt.Printf("  %-16s %s\n", p.Name, p.Description)
+	}
+	return nil
+}
+
+// loadPersona returns the persona called name from the persona directory.
+func loadPersona(name string) (persona.Persona, error) {
+	personas, err := persona.Load(persona.Dir())
+	if err != nil {
+		return persona.Persona{}, err
+	}
+	return persona.Find(personas, name)
+}
@@ -0,0 +1,447 @@
+package repl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"askgo/llm"
+)
+
+// ErrExit is returned by a command to end the session.
+var ErrExit = errors.New("repl: exit")
+
+// Command is a slash command of the chat, e.g. /help.
+type Command struct {
+	// Name is the command without its slash.
+	Name string
+	// Usage describes the arguments, e.g. "[file]".
+	Usage string
+	// Help is a one-line description.
+	Help string
+	// Run executes the command with the rest of the line as args.
+	Run func(s *Session, args string) error
+	// Complete optionally returns the completions of the argument being
+	// typed.
+	Complete func(s *Session, arg string) []string
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = make(map[string]Command)
+)
+
+// Register makes cmd available in every session. It panics if the name is
+// empty or already taken, so packages registering commands from init
+// functions notice clashes at startup.
+func Register(cmd Command) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	if cmd.Name == "" || cmd.Run == nil {
+		panic("repl: Register of command without name or Run")
+	}
+	if _, dup := commands[cmd.Name]; dup {
+		panic("repl: Register called twice for command " + cmd.Name)
+	}
+	commands[cmd.Name] = cmd
+}
+
+// Commands returns the registered commands sorted by name.
+func Commands() []Command {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	list := make([]Command, 0, len(commands))
+	for _, cmd := range commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// lookup returns the command called name.
+func lookup(name string) (Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// Execute runs the slash command on line, e.g. "/model llama3".
+func (s *Session) Execute(line string) error {
+	name, args, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	cmd, ok := lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown command /%s; type /help for a list", name)
+	}
+	return cmd.Run(s, strings.TrimSpace(args))
+}
+
+// complete returns the completions of line: command names after a slash,
+// then whatever the command completes its argument with, and paths after
+// an @ in prompts. Completions are whole lines.
+func (s *Session) complete(line string) []string {
+	if !strings.HasPrefix(line, "/") {
+		start := strings.LastIndexAny(line, " \t\n") + 1
+		if !strings.HasPrefix(line[start:], "@") {
+			return nil
+		}
+		var lines []string
+		for _, path := range completeFiles(s, line[start+1:]) {
+			lines = append(lines, line[:start]+"@"+path)
+		}
+		return lines
+	}
+	name, arg, hasArg := strings.Cut(line[1:], " ")
+	if !hasArg {
+		var names []string
+		for _, cmd := range Commands() {
+			if strings.HasPrefix(cmd.Name, name) {
+				names = append(names, "/"+cmd.Name)
+			}
+		}
+		return names
+	}
+
+	cmd, ok := lookup(name)
+	if !ok || cmd.Complete == nil {
+		return nil
+	}
+	var lines []string
+	for _, c := range cmd.Complete(s, arg) {
+		lines = append(lines, "/"+name+" "+c)
+	}
+	return lines
+}
+
+// completeFiles completes a path argument.
+func completeFiles(_ *Session, arg string) []string {
+	matches, _ := filepath.Glob(arg + "*")
+	for i, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			matches[i] += string(filepath.Separator)
+		}
+	}
+	return matches
+}
+
+// completeCommands completes a command name.
+func completeCommands(_ *Session, arg string) []string {
+	var names []string
+	for _, cmd := range Commands() {
+		if strings.HasPrefix(cmd.Name, arg) {
+			names = append(names, cmd.Name)
+		}
+	}
+	return names
+}
+
+// lastAnswer returns the index of the last assistant message, or -1.
+func (s *Session) lastAnswer() int {
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if s.History[i].Role == llm.RoleAssistant {
+			return i
+		}
+	}
+	return -1
+}
+
+// savedSession is the file format of /save and /load.
+type savedSession struct {
+	Provider string        `json:"provider,omitempty"`
+	Model    string        `json:"model,omitempty"`
+	Params   llm.Params    `json:"params"`
+	System   string        `json:"system,omitempty"`
+	Messages []llm.Message `json:"messages"`
+}
+
+func init() {
+	for _, cmd := range []Command{
+		{
+			Name:     "help",
+			Usage:    "[command]",
+			Help:     "List the commands, or describe one",
+			Run:      runHelp,
+			Complete: completeCommands,
+		},
+		{
+			Name: "exit",
+			Help: "End the chat (also exit or quit)",
+			Run:  func(*Session, string) error { return ErrExit },
+		},
+		{
+			Name: "new",
+			Help: "Start a new conversation, keeping model and system prompt",
+			Run: func(s *Session, _ string) error {
+				s.History = nil
+				s.Notef("Started a new conversation")
+				return nil
+			},
+		},
+		{
+			Name: "history",
+			Help: "Show the conversation so far",
+			Run: func(s *Session, _ string) error {
+				for _, m := range s.Messages() {
+					s.Printf("%s", transcriptLine(m))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "system",
+			Usage: "[prompt|-]",
+			Help:  "Show or set the system prompt; - removes it",
+			Run: func(s *Session, args string) error {
+				switch args {
+				case "":
+					if s.System == "" {
+						s.Printf("No system prompt")
+					} else {
+						s.Printf("%s", s.System)
+					}
+				case "-":
+					s.System = ""
+					s.Notef("System prompt removed")
+				default:
+					s.System = args
+					s.Notef("System prompt set")
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "edit",
+			Usage: "[text]",
+			Help:  "Write a prompt in $EDITOR, starting from text, and send it",
+			Run:   runEdit,
+		},
+		{
+			Name:     "attach",
+			Usage:    "[path...]",
+			Help:     "Attach files or directories to the next prompt, or list them",
+			Run:      runAttach,
+			Complete: completeFiles,
+		},
+		{
+			Name:     "save",
+			Usage:    "<file>",
+			Help:     "Save the conversation and its settings as JSON",
+			Run:      runSave,
+			Complete: completeFiles,
+		},
+		{
+			Name:     "load",
+			Usage:    "<file>",
+			Help:     "Continue a conversation saved with /save",
+			Run:      runLoad,
+			Complete: completeFiles,
+		},
+		{
+			Name: "retry",
+			Help: "Ask for a new answer to the last prompt",
+			Run: func(s *Session, _ string) error {
+				i := len(s.History) - 1
+				for i >= 0 && s.History[i].Role != llm.RoleUser {
+					i--
+				}
+				if i < 0 {
+					return errors.New("nothing to retry")
+				}
+				// The prompt already holds its attachments
+				prompt := s.History[i].Content
+				removed := append([]llm.Message(nil), s.History[i:]...)
+				s.History = s.History[:i]
+				if !s.send(prompt) {
+					// Keep the previous answer rather than losing both
+					s.History = append(s.History, removed...)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "undo",
+			Help: "Remove the last prompt and its answer",
+			Run: func(s *Session, _ string) error {
+				i := len(s.History) - 1
+				for i >= 0 && s.History[i].Role != llm.RoleUser {
+					i--
+				}
+				if i < 0 {
+					return errors.New("nothing to undo")
+				}
+				s.History = s.History[:i]
+				s.Notef("Removed the last exchange")
+				return nil
+			},
+		},
+		{
+			Name: "copy-last",
+			Help: "Copy the last answer to the clipboard",
+			Run: func(s *Session, _ string) error {
+				i := s.lastAnswer()
+				if i < 0 {
+					return errors.New("no answer to copy")
+				}
+				if err := copyToClipboard(s.Out, s.History[i].Content); err != nil {
+					return err
+				}
+				s.Notef("Copied the last answer")
+				return nil
+			},
+		},
+		{
+			Name: "tokens",
+			Help: "Estimate the tokens of the conversation",
+			Run: func(s *Session, _ string) error {
+				t := s.Target()
+				used := llm.CountMessages(s.Messages())
+				window := s.Client.ContextWindow(t.Provider, t.Model)
+				s.Printf("About %d tokens in %d messages; %s has a context window of %d (%d%% used)",
+					used, len(s.History), t, window, used*100/window)
+				return nil
+			},
+		},
+	} {
+		Register(cmd)
+	}
+}
+
+func runHelp(s *Session, args string) error {
+	if args != "" {
+		cmd, ok := lookup(strings.TrimPrefix(args, "/"))
+		if !ok {
+			return fmt.Errorf("unknown command /%s", strings.TrimPrefix(args, "/"))
+		}
+		s.Printf("/%s %s\n  %s", cmd.Name, cmd.Usage, cmd.Help)
+		return nil
+	}
+	s.Printf("Commands (Tab completes them):")
+	for _, cmd := range Commands() {
+		s.Printf("  %-22s %s", strings.TrimSpace("/"+cmd.Name+" "+cmd.Usage), cmd.Help)
+	}
+	return nil
+}
+
+// runAttach queues paths for the next prompt; without any it lists those
+// queued.
+func runAttach(s *Session, args string) error {
+	paths := strings.Fields(args)
+	if len(paths) == 0 {
+		if len(s.Attach) == 0 {
+			s.Printf("Nothing attached; files can also be referenced as @path in a prompt")
+		}
+		for _, path := range s.Attach {
+			s.Printf("  %s", path)
+		}
+		return nil
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	s.Attach = append(s.Attach, paths...)
+	s.Notef("Will attach %s to the next prompt", strings.Join(s.Attach, ", "))
+	return nil
+}
+
+// runEdit opens $VISUAL or $EDITOR on a temporary file holding args and
+// sends what the user saved.
+func runEdit(s *Session, args string) error {
+	file, err := os.CreateTemp("", "askgo-*.md")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(args)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Blank values would leave no command to run
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	// The variable may carry flags, e.g. "code --wait"
+	words := strings.Fields(editor)
+	cmd := exec.Command(words[0], append(words[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+	prompt := strings.TrimSpace(string(data))
+	if prompt == "" {
+		s.Notef("Empty prompt, nothing sent")
+		return nil
+	}
+	fmt.Fprintln(s.Out, userColor("You: ")+prompt)
+	s.Send(prompt)
+	return nil
+}
+
+func runSave(s *Session, path string) error {
+	if path == "" {
+		return errors.New("usage: /save <file>")
+	}
+	data, err := json.MarshalIndent(savedSession{
+		Provider: s.Options.Provider,
+		Model:    s.Options.Model,
+		Params:   s.Options.Params,
+		System:   s.System,
+		Messages: s.History,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	s.Notef("Saved %d messages to %s", len(s.History), path)
+	return nil
+}
+
+func runLoad(s *Session, path string) error {
+	if path == "" {
+		return errors.New("usage: /load <file>")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s is not a saved conversation: %w", path, err)
+	}
+	if err := saved.Params.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.Options = llm.Options{Provider: saved.Provider, Model: saved.Model, Params: saved.Params}
+	s.System = saved.System
+	s.History = saved.Messages
+	s.Notef("Loaded %d messages from %s (%s)", len(s.History), path, s.Target())
+	return nil
+}
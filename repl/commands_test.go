@@ -0,0 +1,157 @@
+package repl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mustPanic fails t unless f panics with a message containing want.
+func mustPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("no panic, want one mentioning %q", want)
+		}
+		if !strings.Contains(fmt.Sprint(r), want) {
+			t.Errorf("panic %q, want one mentioning %q", r, want)
+		}
+	}()
+	f()
+}
+
+func TestRegister(t *testing.T) {
+	var got string
+	Register(Command{
+		Name:  "test-echo",
+		Usage: "<text>",
+		Help:  "Echo the text",
+		Run: func(s *Session, args string) error {
+			got = args
+			return nil
+		},
+	})
+
+	s := &Session{Out: &bytes.Buffer{}}
+	if err := s.Execute("/test-echo  hello world "); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got != "hello world" {
+		t.Errorf("args = %q, want %q", got, "hello world")
+	}
+
+	names := make([]string, 0)
+	for _, cmd := range Commands() {
+		names = append(names, cmd.Name)
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Commands not sorted: %v", names)
+	}
+	if _, ok := lookup("test-echo"); !ok {
+		t.Errorf("Commands() = %v, missing test-echo", names)
+	}
+
+	if err := s.Execute("/no-such-command"); err == nil || !strings.Contains(err.Error(), "/help") {
+		t.Errorf("unknown command error = %v, want a pointer to /help", err)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	run := func(*Session, string) error { return nil }
+	mustPanic(t, "twice for command help", func() {
+		Register(Command{Name: "help", Run: run})
+	})
+	mustPanic(t, "without name", func() {
+		Register(Command{Run: run})
+	})
+	mustPanic(t, "without name or Run", func() {
+		Register(Command{Name: "test-no-run"})
+	})
+	if _, ok := lookup("test-no-run"); ok {
+		t.Error("command without Run was registered")
+	}
+}
+
+func TestCompleteCommands(t *testing.T) {
+	Register(Command{
+		Name: "test-color",
+		Run:  func(*Session, string) error { return nil },
+		Complete: func(_ *Session, arg string) []string {
+			var matches []string
+			for _, c := range []string{"red", "green", "grey"} {
+				if strings.HasPrefix(c, arg) {
+					matches = append(matches, c)
+				}
+			}
+			return matches
+		},
+	})
+
+	s := &Session{}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"/sa", []string{"/save"}},
+		{"/test-col", []string{"/test-color"}},
+		{"/hist", []string{"/history"}},
+		{"/zz", nil},
+		{"/test-color g", []string{"/test-color green", "/test-color grey"}},
+		{"/test-color r", []string{"/test-color red"}},
+		{"/test-color x", nil},
+		{"/help sy", []string{"/help system"}},
+		// Commands without a completer and unknown ones complete nothing
+		{"/new x", nil},
+		{"/unknown x", nil},
+		// Prompts only complete @paths
+		{"hello", nil},
+		{"hello wor", nil},
+	}
+	for _, tt := range tests {
+		if got := s.complete(tt.line); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"notes.md", "notes.txt", "main.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sep := string(filepath.Separator)
+	p := func(name string) string { return filepath.Join(dir, name) }
+
+	s := &Session{}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		// Arguments of commands completing files
+		{"/attach " + p("no"), []string{"/attach " + p("notes.md"), "/attach " + p("notes.txt")}},
+		{"/load " + p("ma"), []string{"/load " + p("main.go")}},
+		{"/save " + p("sr"), []string{"/save " + p("src") + sep}},
+		{"/attach " + p("x"), nil},
+		// @paths anywhere in a prompt
+		{"explain @" + p("ma"), []string{"explain @" + p("main.go")}},
+		{"@" + p("sr"), []string{"@" + p("src") + sep}},
+		{"compare @" + p("main.go") + " and @" + p("notes.t"), []string{"compare @" + p("main.go") + " and @" + p("notes.txt")}},
+		{"multi\nline @" + p("ma"), []string{"multi\nline @" + p("main.go")}},
+		{"mail me@" + p("ma"), nil},
+	}
+	for _, tt := range tests {
+		if got := s.complete(tt.line); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// historyLimit is the number of prompts kept in the history file.
+const historyLimit = 1000
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C.
+var errInterrupted = errors.New("repl: interrupted")
+
+// lineReader reads the user's input one line at a time.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	// AddHistory records a prompt for recall with the arrow keys.
+	AddHistory(entry string)
+	Close() error
+}
+
+// newLineReader returns an editor for terminals and a plain reader for
+// pipes and files. complete returns the completions of a whole line;
+// historyFile, if set, keeps the editor's history between sessions.
+func newLineReader(in io.Reader, out io.Writer, complete func(string) []string, historyFile string) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		e := &editor{fd: int(f.Fd()), in: bufio.NewReader(f), out: out, complete: complete, historyFile: historyFile}
+		e.loadHistory()
+		return e
+	}
+	return &plainReader{in: bufio.NewReader(in), out: out}
+}
+
+// DefaultHistoryFile returns where the prompts typed in the chat are kept,
+// askgo/history in the user's config directory, or "" if there is none.
+func DefaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "askgo", "history")
+}
+
+// plainReader reads lines without any editing.
+type plainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (r *plainReader) AddHistory(string) {}
+
+func (r *plainReader) Close() error { return nil }
+
+// editor is a minimal line editor for terminals: cursor movement, the
+// usual Emacs control keys, history, tab completion and multiline input
+// with Alt-Enter or pasting. The terminal is only in raw mode while a line
+// is being read, so answers print normally.
+type editor struct {
+	// fd is the terminal's file descriptor, or -1 to leave the terminal
+	// mode alone, as in tests.
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	complete func(string) []string
+
+	history     []string
+	historyFile string
+
+	// State of the line being edited
+	prompt    string
+	line      []rune
+	pos       int
+	cursorRow int
+	// histPos is the history entry shown, len(history) for the new line,
+	// whose text draft keeps while browsing.
+	histPos int
+	draft   []rune
+}
+
+// Control keys understood by the editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func (e *editor) ReadLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		state, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer restoreTerminal(e.fd, state)
+	}
+
+	// Bracketed paste marks pasted text, whose newlines must not send it
+	fmt.Fprint(e.out, "\x1b[?2004h")
+	defer fmt.Fprint(e.out, "\x1b[?2004l")
+
+	e.prompt, e.line, e.pos, e.cursorRow = prompt, nil, 0, 0
+	e.histPos, e.draft = len(e.history), nil
+	e.refresh()
+
+	lastTab := false
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		tab := false
+
+		switch r {
+		case keyEnter, '\n':
+			e.pos = len(e.line)
+			e.refresh()
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.pos = max(e.pos-1, 0)
+		case keyCtrlF:
+			e.pos = min(e.pos+1, len(e.line))
+		case keyCtrlK:
+			e.line = e.line[:e.pos]
+		case keyCtrlU:
+			e.line = append([]rune(nil), e.line[e.pos:]...)
+			e.pos = 0
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.line = append(e.line[:start], e.line[e.pos:]...)
+			e.pos = start
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			e.cursorRow = 0
+		case keyCtrlP:
+			e.browse(-1)
+		case keyCtrlN:
+			e.browse(1)
+		case keyTab:
+			tab = true
+			e.completeLine(lastTab)
+		case keyEscape:
+			e.escape()
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.insert(r)
+			}
+		}
+		lastTab = tab
+		e.refresh()
+	}
+}
+
+func (e *editor) Close() error { return nil }
+
+// AddHistory appends entry to the history and its file, skipping blank
+// entries and repeats of the last one.
+func (e *editor) AddHistory(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == entry) {
+		return
+	}
+	e.history = append(e.history, entry)
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, strconv.Quote(entry))
+}
+
+// loadHistory reads the history file, which holds one quoted entry per
+// line so multiline prompts survive, and trims it to historyLimit.
+func (e *editor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		os.MkdirAll(filepath.Dir(e.historyFile), 0700)
+		return
+	} else if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for _, line := range lines {
+		if entry, err := strconv.Unquote(line); err == nil {
+			e.history = append(e.history, entry)
+		}
+	}
+	if len(lines) > historyLimit {
+		e.history = e.history[max(len(e.history)-historyLimit, 0):]
+		var b strings.Builder
+		for _, entry := range e.history {
+			b.WriteString(strconv.Quote(entry) + "\n")
+		}
+		os.WriteFile(e.historyFile, []byte(b.String()), 0600)
+	}
+}
+
+// browse shows the history entry delta steps away from the current one.
+func (e *editor) browse(delta int) {
+	next := e.histPos + delta
+	if next < 0 || next > len(e.history) {
+		return
+	}
+	if e.histPos == len(e.history) {
+		e.draft = append([]rune(nil), e.line...)
+	}
+	e.histPos = next
+	if next == len(e.history) {
+		e.setLine(string(e.draft))
+	} else {
+		e.setLine(e.history[next])
+	}
+}
+
+func (e *editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = r
+	e.pos++
+}
+
+func (e *editor) deleteAt(i int) {
+	if i < len(e.line) {
+		e.line = append(e.line[:i], e.line[i+1:]...)
+	}
+}
+
+// escape handles Alt-Enter, pasted text and the ANSI escape sequences sent
+// by arrow, Home, End and Delete keys. Others are read and ignored.
+// Terminals write a sequence at once, so an Esc with nothing after it was
+// pressed on its own and is ignored rather than waiting for the next key.
+func (e *editor) escape() {
+	if e.in.Buffered() == 0 {
+		return
+	}
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return
+	}
+	if r == keyEnter || r == '\n' {
+		e.insert('\n')
+		return
+	}
+	if r != '[' && r != 'O' {
+		return
+	}
+	var params strings.Builder
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		params.WriteRune(r)
+	}
+
+	switch {
+	case r == 'A':
+		e.browse(-1)
+	case r == 'B':
+		e.browse(1)
+	case r == '~' && params.String() == "200":
+		e.paste()
+	case r == 'C':
+		e.pos = min(e.pos+1, len(e.line))
+	case r == 'D':
+		e.pos = max(e.pos-1, 0)
+	case r == 'H', r == '~' && (params.String() == "1" || params.String() == "7"):
+		e.pos = 0
+	case r == 'F', r == '~' && (params.String() == "4" || params.String() == "8"):
+		e.pos = len(e.line)
+	case r == '~' && params.String() == "3":
+		e.deleteAt(e.pos)
+	}
+}
+
+// paste inserts pasted text up to the end marker of bracketed paste.
+func (e *editor) paste() {
+	const end = "\x1b[201~"
+	var text []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			break
+		}
+		text = append(text, r)
+		if strings.HasSuffix(string(text[max(len(text)-len(end), 0):]), end) {
+			text = text[:len(text)-len(end)]
+			break
+		}
+	}
+	for _, r := range strings.ReplaceAll(string(text), "\r\n", "\n") {
+		switch {
+		case r == '\r':
+			e.insert('\n')
+		case r == '\t':
+			// Tabs would throw off the cursor arithmetic of refresh
+			for i := 0; i < 4; i++ {
+				e.insert(' ')
+			}
+		case r == '\n' || r >= ' ':
+			e.insert(r)
+		}
+	}
+}
+
+// completeLine completes the line at the cursor: a single candidate is
+// taken over, several are completed to their common prefix and listed on
+// the second Tab.
+func (e *editor) completeLine(again bool) {
+	if e.complete == nil || e.pos != len(e.line) {
+		return
+	}
+	line := string(e.line)
+	candidates := e.complete(line)
+
+	switch len(candidates) {
+	case 0:
+		fmt.Fprint(e.out, "\a")
+		return
+	case 1:
+		// Separators like those of paths and provider:model mean there
+		// is more to type
+		completed := candidates[0]
+		if !strings.HasSuffix(completed, string(os.PathSeparator)) && !strings.HasSuffix(completed, ":") {
+			completed += " "
+		}
+		e.setLine(completed)
+		return
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(line) {
+		e.setLine(prefix)
+		return
+	}
+	if !again {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+
+	// List the candidates by their last word below the line
+	words := make([]string, len(candidates))
+	for i, c := range candidates {
+		words[i] = c[strings.LastIndex(c, " ")+1:]
+	}
+	e.pos = len(e.line)
+	e.refresh()
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(words, "  "))
+	e.cursorRow = 0
+}
+
+func (e *editor) setLine(s string) {
+	e.line = []rune(s)
+	e.pos = len(e.line)
+}
+
+// refresh redraws the prompt and line, which may span several rows, and
+// places the cursor.
+func (e *editor) refresh() {
+	width := terminalWidth(e.fd)
+	promptWidth := utf8.RuneCountInString(stripANSI(e.prompt))
+
+	if e.cursorRow > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dA", e.cursorRow)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[J", e.prompt, strings.ReplaceAll(string(e.line), "\n", "\r\n"))
+
+	// At the right margin the cursor waits for the next character before
+	// wrapping; move it to the next row so the arithmetic below holds
+	endRow, endCol := e.layout(len(e.line), promptWidth, width)
+	if endCol == width {
+		fmt.Fprint(e.out, "\r\n")
+		endRow++
+	}
+
+	row, col := e.layout(e.pos, promptWidth, width)
+	if col == width {
+		row, col = row+1, 0
+	}
+	if up := endRow - row; up > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dA", up)
+	}
+	fmt.Fprint(e.out, "\r")
+	if col > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", col)
+	}
+	e.cursorRow = row
+}
+
+// layout returns the row and column after the first n runes of the line.
+// Like the terminal, it only wraps when the next rune is written, so the
+// column may equal width.
+func (e *editor) layout(n, promptWidth, width int) (row, col int) {
+	col = promptWidth % width
+	for _, r := range e.line[:n] {
+		if r == '\n' {
+			row, col = row+1, 0
+			continue
+		}
+		if col == width {
+			row, col = row+1, 0
+		}
+		col++
+	}
+	return row, col
+}
+
+// stripANSI removes the color escape sequences from s.
+func stripANSI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '[' {
+			i += 2
+			for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+				i++
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
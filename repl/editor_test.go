@@ -0,0 +1,283 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"askgo/llm/llmtest"
+)
+
+// newTestEditor returns an editor reading keys from in, as a terminal
+// would deliver them, without touching the real terminal.
+func newTestEditor(in io.Reader, history ...string) *editor {
+	return &editor{fd: -1, in: bufio.NewReader(in), out: io.Discard, history: history}
+}
+
+func TestEditorKeys(t *testing.T) {
+	history := []string{"first", "second"}
+	tests := []struct {
+		name string
+		keys string
+		want string
+	}{
+		{"typing", "hello\r", "hello"},
+		{"newline enter", "hello\n", "hello"},
+		{"backspace", "hellp\x7fo\r", "hello"},
+		{"ctrl-h", "hellp\x08o\r", "hello"},
+		{"ctrl-a", "world\x01hello \r", "hello world"},
+		{"ctrl-e", "bc\x01a\x05d\r", "abcd"},
+		{"ctrl-b ctrl-f", "ac\x02b\x06d\r", "abcd"},
+		{"ctrl-b at start", "\x02\x02a\r", "a"},
+		{"ctrl-k", "hello world\x01\x06\x06\x06\x06\x06\x0b\r", "hello"},
+		{"ctrl-u", "hello world\x02\x02\x02\x02\x02\x15\r", "world"},
+		{"ctrl-w", "hello big world\x17\r", "hello big "},
+		{"ctrl-w trailing spaces", "hello big  \x17\r", "hello "},
+		{"ctrl-d deletes", "abc\x01\x04\r", "bc"},
+		{"arrows", "ac\x1b[Db\x1b[Cd\r", "abcd"},
+		{"home end", "bc\x1b[Ha\x1b[Fd\r", "abcd"},
+		{"home end tilde", "bc\x1b[1~a\x1b[4~d\r", "abcd"},
+		{"delete key", "abc\x1b[H\x1b[3~\r", "bc"},
+		{"application mode arrows", "ac\x1bODb\r", "abc"},
+		{"unknown sequence", "a\x1b[Zb\r", "ab"},
+		{"alt key", "a\x1bxb\r", "ab"},
+		{"alt-enter", "one\x1b\rtwo\r", "one\ntwo"},
+		{"paste", "\x1b[200~a\r\nb\tc\rd\x1b[201~\r", "a\nb    c\nd"},
+		{"paste control characters", "\x1b[200~a\x07b\x1b[201~\r", "ab"},
+		{"up", "\x1b[A\r", "second"},
+		{"up up", "\x1b[A\x1b[A\r", "first"},
+		{"up past oldest", "\x1b[A\x1b[A\x1b[A\r", "first"},
+		{"up down keeps draft", "draft\x1b[A\x1b[B\r", "draft"},
+		{"down past newest", "draft\x1b[B\r", "draft"},
+		{"ctrl-p ctrl-n", "\x10\x10\x0e\r", "second"},
+		{"edit history entry", "\x1b[A!\r", "second!"},
+		{"ctrl-l", "a\x0cb\r", "ab"},
+		{"control characters ignored", "a\x07\x00b\r", "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(strings.NewReader(tt.keys), history...)
+			got, err := e.ReadLine("> ")
+			if err != nil {
+				t.Fatalf("ReadLine: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ReadLine = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		want error
+	}{
+		{"ctrl-c", "abc\x03", errInterrupted},
+		{"ctrl-d on empty line", "\x04", io.EOF},
+		{"end of input", "abc", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(strings.NewReader(tt.keys))
+			if _, err := e.ReadLine("> "); !errors.Is(err, tt.want) {
+				t.Errorf("ReadLine error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// A lone Esc arrives on its own; it must not swallow the next key.
+func TestEditorLoneEscape(t *testing.T) {
+	e := newTestEditor(iotest.OneByteReader(strings.NewReader("a\x1bb\r")))
+	got, err := e.ReadLine("> ")
+	if err != nil {
+		t.Fatalf("ReadLine: %v", err)
+	}
+	if got != "ab" {
+		t.Errorf("ReadLine = %q, want %q", got, "ab")
+	}
+}
+
+func TestEditorComplete(t *testing.T) {
+	candidates := map[string][]string{
+		"/he":  {"/help"},
+		"@di":  {"@dir" + string(os.PathSeparator)},
+		"/s":   {"/save", "/system"},
+		"/sa":  {"/save"},
+		"/x":   nil,
+		"/sys": {"/system"},
+	}
+	complete := func(line string) []string { return candidates[line] }
+
+	tests := []struct {
+		name string
+		keys string
+		want string
+	}{
+		{"single", "/he\t\r", "/help "},
+		{"directory", "@di\t\r", "@dir" + string(os.PathSeparator)},
+		{"prefix then single", "/s\ta\t\r", "/save "},
+		{"no candidates", "/x\t\r", "/x"},
+		{"not at end of line", "/sysX\x02\t\r", "/sysX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(strings.NewReader(tt.keys))
+			e.complete = complete
+			got, err := e.ReadLine("> ")
+			if err != nil {
+				t.Fatalf("ReadLine: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ReadLine = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The first Tab completes the common prefix, the second lists the
+// candidates by their last word.
+func TestEditorCompleteList(t *testing.T) {
+	var out bytes.Buffer
+	e := newTestEditor(strings.NewReader("/model \t\t\r"))
+	e.out = &out
+	e.complete = func(string) []string { return []string{"/model groq:a", "/model groq:b"} }
+	got, err := e.ReadLine("> ")
+	if err != nil {
+		t.Fatalf("ReadLine: %v", err)
+	}
+	if got != "/model groq:" {
+		t.Errorf("ReadLine = %q, want %q", got, "/model groq:")
+	}
+	if !strings.Contains(out.String(), "groq:a  groq:b") {
+		t.Errorf("output %q does not list the candidates", out.String())
+	}
+}
+
+func TestEditorHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "askgo", "history")
+
+	e := &editor{fd: -1, historyFile: path}
+	e.loadHistory()
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		t.Fatalf("history directory not created: %v", err)
+	}
+	for _, entry := range []string{"one", "  ", "two\nlines", "two\nlines", "three"} {
+		e.AddHistory(entry)
+	}
+	want := []string{"one", "two\nlines", "three"}
+	if fmt.Sprint(e.history) != fmt.Sprint(want) {
+		t.Errorf("history = %q, want %q", e.history, want)
+	}
+
+	loaded := &editor{fd: -1, historyFile: path}
+	loaded.loadHistory()
+	if fmt.Sprint(loaded.history) != fmt.Sprint(want) {
+		t.Errorf("loaded history = %q, want %q", loaded.history, want)
+	}
+
+	// Recalling a multiline entry brings back all of its lines
+	loaded.in = bufio.NewReader(strings.NewReader("\x1b[A\x1b[A\r"))
+	loaded.out = io.Discard
+	got, err := loaded.ReadLine("> ")
+	if err != nil {
+		t.Fatalf("ReadLine: %v", err)
+	}
+	if got != "two\nlines" {
+		t.Errorf("ReadLine = %q, want %q", got, "two\nlines")
+	}
+}
+
+func TestEditorHistoryLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var b strings.Builder
+	for i := 0; i < historyLimit+10; i++ {
+		b.WriteString(strconv.Quote(fmt.Sprint("entry ", i)) + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &editor{fd: -1, historyFile: path}
+	e.loadHistory()
+	if len(e.history) != historyLimit {
+		t.Fatalf("loaded %d entries, want %d", len(e.history), historyLimit)
+	}
+	if e.history[0] != "entry 10" {
+		t.Errorf("oldest entry = %q, want %q", e.history[0], "entry 10")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != historyLimit {
+		t.Errorf("history file has %d lines, want %d", n, historyLimit)
+	}
+}
+
+func TestReadPrompt(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"single line", "hello\n", "hello"},
+		{"multiline", "\"\"\"first\nsecond\nthird\"\"\"\n", "first\nsecond\nthird"},
+		{"multiline on one line", "\"\"\"quoted\"\"\"\n", "quoted"},
+		{"unterminated", "\"\"\"first\nsecond", "first\nsecond"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := &plainReader{in: bufio.NewReader(strings.NewReader(tt.input)), out: io.Discard}
+			got, err := readPrompt(lines)
+			if err != nil {
+				t.Fatalf("readPrompt: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readPrompt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunEditBlankEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell utility as the editor")
+	}
+	truePath, err := exec.LookPath("true")
+	if err != nil {
+		t.Skip("true not found")
+	}
+
+	// A blank $VISUAL falls through to $EDITOR
+	t.Setenv("VISUAL", "   ")
+	t.Setenv("EDITOR", truePath)
+	fake := llmtest.NewFakeProvider(llmtest.Reply{Content: "ok"})
+	s := &Session{Client: llmtest.NewClient(fake), Out: io.Discard}
+	if err := s.Execute("/edit draft"); err != nil {
+		t.Fatalf("/edit: %v", err)
+	}
+	if len(s.History) != 2 || s.History[0].Content != "draft" {
+		t.Errorf("history = %+v, want the draft and its answer", s.History)
+	}
+
+	// Blank values everywhere fall back to the default editor instead of
+	// panicking; with an empty PATH it cannot be found
+	t.Setenv("EDITOR", " ")
+	t.Setenv("PATH", "")
+	if err := s.Execute("/edit draft"); err == nil {
+		t.Error("/edit with no editor on PATH succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+package repl
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+)
+
+// Colors of highlighted code.
+var (
+	keywordColor = color.New(color.FgMagenta).SprintFunc()
+	stringColor  = color.New(color.FgGreen).SprintFunc()
+	commentColor = color.New(color.FgHiBlack).SprintFunc()
+	numberColor  = color.New(color.FgYellow).SprintFunc()
+)
+
+// syntax is what the highlighter knows of a language: its keywords,
+// comments and string quotes.
+type syntax struct {
+	keywords map[string]bool
+	// ignoreCase matches keywords in any case, as in SQL.
+	ignoreCase bool
+	// lineComment starts a comment running to the end of the line.
+	lineComment string
+	// blockComments are /* C-style */ comments.
+	blockComments bool
+	quotes        string
+}
+
+// genericSyntax highlights strings and numbers of unknown languages.
+var genericSyntax = &syntax{quotes: `"'`}
+
+// syntaxes maps the language names of fenced code blocks to their syntax.
+var syntaxes = make(map[string]*syntax)
+
+// addSyntax registers s under names with the given keywords.
+func addSyntax(names string, keywords string, s *syntax) {
+	s.keywords = make(map[string]bool)
+	for _, word := range strings.Fields(keywords) {
+		s.keywords[word] = true
+	}
+	for _, name := range strings.Fields(names) {
+		syntaxes[name] = s
+	}
+}
+
+func init() {
+	addSyntax("go golang", `break case chan const continue default defer else
+		fallthrough for func go goto if import interface map package range
+		return select struct switch type var nil true false`,
+		&syntax{lineComment: "//", blockComments: true, quotes: "\"'`"})
+	addSyntax("python py python3", `and as assert async await break class
+		continue def del elif else except finally for from global if import
+		in is lambda nonlocal not or pass raise return try while with yield
+		None True False self`,
+		&syntax{lineComment: "#", quotes: `"'`})
+	addSyntax("javascript js jsx typescript ts tsx", `async await break case
+		catch class const continue debugger default delete do else enum
+		export extends finally for function if implements import in
+		instanceof interface let new of return super switch this throw try
+		type typeof var void while yield null undefined true false`,
+		&syntax{lineComment: "//", blockComments: true, quotes: "\"'`"})
+	addSyntax("java kotlin kt", `abstract boolean break byte case catch char
+		class continue default do double else enum extends final finally
+		float for fun if implements import instanceof int interface long new
+		package private protected public return short static super switch
+		this throw throws try val var void while null true false`,
+		&syntax{lineComment: "//", blockComments: true, quotes: `"'`})
+	addSyntax("c h cpp c++ cc hpp cs csharp", `auto bool break case char class
+		const continue default delete do double else enum extern false float
+		for goto if include define int long namespace new nullptr private
+		protected public return short signed sizeof static struct switch
+		template true typedef typename union unsigned using virtual void
+		volatile while`,
+		&syntax{lineComment: "//", blockComments: true, quotes: `"'`})
+	addSyntax("rust rs", `as async await break const continue crate dyn else
+		enum extern false fn for if impl in let loop match mod move mut pub
+		ref return self Self static struct super trait true type unsafe use
+		where while Some None Ok Err`,
+		&syntax{lineComment: "//", blockComments: true, quotes: `"`})
+	addSyntax("ruby rb", `begin class def do else elsif end ensure false for
+		if in module nil require rescue return self then true unless until
+		when while yield`,
+		&syntax{lineComment: "#", quotes: `"'`})
+	addSyntax("sh bash shell zsh console", `case do done echo elif else esac
+		exit export fi for function if in local return then until while`,
+		&syntax{lineComment: "#", quotes: `"'`})
+	addSyntax("sql", `select from where and or not insert into values update
+		set delete create table index drop alter join left right inner outer
+		on group by order having limit as null is in like distinct primary
+		key foreign references`,
+		&syntax{ignoreCase: true, lineComment: "--", quotes: `'"`})
+	addSyntax("json", `true false null`, &syntax{quotes: `"`})
+	addSyntax("yaml yml toml", `true false null`, &syntax{lineComment: "#", quotes: `"'`})
+}
+
+// lookupSyntax returns the syntax of a code block's language.
+func lookupSyntax(lang string) *syntax {
+	if s, ok := syntaxes[strings.ToLower(lang)]; ok {
+		return s
+	}
+	return genericSyntax
+}
+
+// highlight colors a line of the open code block. Block comments may span
+// lines, so whether one is open is kept in m.inComment.
+func (m *markdownWriter) highlight(line string) string {
+	s := m.lang
+	var b strings.Builder
+	rs := []rune(line)
+	for i := 0; i < len(rs); {
+		rest := string(rs[i:])
+		switch {
+		case m.inComment:
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				b.WriteString(commentColor(rest))
+				return b.String()
+			}
+			b.WriteString(commentColor(rest[:end+2]))
+			i += len([]rune(rest[:end+2]))
+			m.inComment = false
+
+		case s.blockComments && strings.HasPrefix(rest, "/*"):
+			m.inComment = true
+			b.WriteString(commentColor("/*"))
+			i += 2
+
+		case s.lineComment != "" && strings.HasPrefix(rest, s.lineComment) &&
+			(s.lineComment != "#" || i == 0 || unicode.IsSpace(rs[i-1])):
+			b.WriteString(commentColor(rest))
+			return b.String()
+
+		case strings.ContainsRune(s.quotes, rs[i]):
+			j := i + 1
+			for j < len(rs) && rs[j] != rs[i] {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(rs))
+			b.WriteString(stringColor(string(rs[i:j])))
+			i = j
+
+		case unicode.IsDigit(rs[i]) && (i == 0 || !isIdentRune(rs[i-1])):
+			j := i
+			for j < len(rs) && (isIdentRune(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			b.WriteString(numberColor(string(rs[i:j])))
+			i = j
+
+		case isIdentRune(rs[i]):
+			j := i
+			for j < len(rs) && isIdentRune(rs[j]) {
+				j++
+			}
+			word := string(rs[i:j])
+			key := word
+			if s.ignoreCase {
+				key = strings.ToLower(word)
+			}
+			if s.keywords[key] {
+				word = keywordColor(word)
+			}
+			b.WriteString(word)
+			i = j
+
+		default:
+			b.WriteRune(rs[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,520 @@
+package repl
+
+import (
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// markdownWriter renders markdown as formatted terminal text while it
+// streams in. Lines are classified as soon as their start is known, so
+// paragraphs print word by word and wrap at the terminal width; code
+// blocks print line by line with syntax highlighting, and tables once they
+// are complete so their columns line up. Call Flush after the last Write.
+type markdownWriter struct {
+	out   io.Writer
+	width int
+
+	// pending holds text not rendered yet; started is set once the block
+	// syntax at the start of the current line has been handled.
+	pending string
+	started bool
+
+	// Output position: the column, the text starting wrapped rows and
+	// whether anything has been written
+	col         int
+	prefix      string
+	prefixWidth int
+	printed     bool
+	// blank is set by an empty line, printed before the next block.
+	blank bool
+
+	// Inline state of the current line
+	word                       strings.Builder
+	space, rowHasWord          bool
+	bold, italic, strike, code bool
+	heading                    int
+
+	// Block state: the fence and language of an open code block and the
+	// rows of a table
+	fence     string
+	lang      *syntax
+	inComment bool
+	table     []string
+}
+
+// newMarkdownWriter returns a renderer wrapping at width whose first line
+// starts at column col.
+func newMarkdownWriter(out io.Writer, width, col int) *markdownWriter {
+	return &markdownWriter{out: out, width: max(width, 20), col: col}
+}
+
+// WriteString renders the next fragment of the text.
+func (m *markdownWriter) WriteString(s string) {
+	m.pending += s
+	for {
+		line, rest, complete := strings.Cut(m.pending, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if !m.started {
+			if !complete && !m.decidable(line) {
+				return
+			}
+			text, inline := m.startLine(line)
+			if !inline {
+				m.pending = rest
+				continue
+			}
+			m.started = true
+			line = text
+		}
+		m.inline(line, complete)
+		if !complete {
+			m.pending = ""
+			return
+		}
+		m.pending, m.started = rest, false
+	}
+}
+
+// Flush renders what is left and ends the output with a newline.
+func (m *markdownWriter) Flush() {
+	if m.pending != "" || m.started {
+		m.WriteString("\n")
+	}
+	if len(m.table) > 0 {
+		m.flushTable()
+	}
+	if m.col > 0 {
+		m.write("\n")
+	}
+	m.col = 0
+}
+
+func (m *markdownWriter) write(s string) {
+	io.WriteString(m.out, s)
+	m.printed = true
+}
+
+// decidable reports whether the start of a partial line is enough to
+// render it as it streams in: it must be paragraph-like text whose block
+// syntax is complete, not possibly a fence, rule or table row.
+func (m *markdownWriter) decidable(partial string) bool {
+	if m.fence != "" || len(m.table) > 0 {
+		return false
+	}
+	t := strings.TrimLeft(partial, " ")
+	switch {
+	case t == "":
+		return false
+	case strings.HasPrefix(t, "|"):
+		return false
+	case strings.HasPrefix("```", t) || strings.HasPrefix(t, "```"),
+		strings.HasPrefix("~~~", t) || strings.HasPrefix(t, "~~~"):
+		return false
+	case strings.Trim(t, "-*_ ") == "", strings.Trim(t, "#") == "", t == ">":
+		return false
+	case strings.TrimRight(t, "0123456789") == "",
+		strings.Trim(t, "0123456789") == "." || strings.Trim(t, "0123456789") == ")":
+		return false
+	}
+	if _, rest, ok := listItem(t); ok && rest != "" {
+		// Wait for the box of a task list item
+		for _, box := range []string{"[ ] ", "[x] ", "[X] "} {
+			if strings.HasPrefix(box, rest) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// startLine handles the block syntax at the start of line. Code lines,
+// table rows, rules and fences are rendered whole; for any other line the
+// prefix is written and the inline text following it returned.
+func (m *markdownWriter) startLine(line string) (text string, inline bool) {
+	t := strings.TrimLeft(line, " \t")
+	indent := len(line) - len(t)
+
+	if m.fence != "" {
+		if strings.HasPrefix(t, m.fence) && strings.Trim(t, m.fence[:1]) == "" {
+			m.fence, m.lang, m.inComment = "", nil, false
+			return "", false
+		}
+		m.write("  " + m.highlight(strings.ReplaceAll(line, "\t", "    ")) + "\n")
+		return "", false
+	}
+
+	if strings.HasPrefix(t, "|") {
+		m.table = append(m.table, t)
+		return "", false
+	} else if len(m.table) > 0 {
+		m.flushTable()
+	}
+
+	switch {
+	case t == "":
+		if m.printed {
+			m.blank = true
+		}
+		return "", false
+
+	case strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~"):
+		m.block()
+		m.fence = t[:len(t)-len(strings.TrimLeft(t, t[:1]))]
+		info := strings.Fields(strings.TrimLeft(t, t[:1]))
+		m.lang = genericSyntax
+		if len(info) > 0 {
+			m.lang = lookupSyntax(info[0])
+		}
+		return "", false
+
+	case isRule(t):
+		m.block()
+		m.write(noteColor(strings.Repeat("─", min(m.width, 80))) + "\n")
+		return "", false
+
+	case strings.HasPrefix(t, "#"):
+		level := len(t) - len(strings.TrimLeft(t, "#"))
+		if level <= 6 && strings.HasPrefix(t[level:], " ") {
+			m.block()
+			m.heading = level
+			return strings.TrimLeft(t[level:], " "), true
+		}
+
+	case strings.HasPrefix(t, ">"):
+		m.separate()
+		m.setPrefix(noteColor("│ "), 2)
+		return strings.TrimPrefix(t[1:], " "), true
+	}
+
+	if marker, rest, ok := listItem(t); ok {
+		m.block()
+		pad := strings.Repeat(" ", indent)
+		m.write(pad + aiColor(marker) + " ")
+		m.col = indent + utf8.RuneCountInString(marker) + 1
+		m.prefix, m.prefixWidth = strings.Repeat(" ", m.col), m.col
+		return rest, true
+	}
+
+	// A paragraph, which may continue the first line after "AI: "
+	m.separate()
+	if indent > 0 {
+		m.setPrefix(strings.Repeat(" ", indent), indent)
+	}
+	return t, true
+}
+
+// separate writes the blank line left by an empty source line.
+func (m *markdownWriter) separate() {
+	if m.blank && m.printed {
+		m.write("\n")
+	}
+	m.blank = false
+}
+
+// block starts a block that must begin on a line of its own.
+func (m *markdownWriter) block() {
+	m.separate()
+	if m.col > 0 {
+		m.write("\n")
+		m.col = 0
+	}
+}
+
+// setPrefix writes prefix, which also starts the rows wrapped from it.
+func (m *markdownWriter) setPrefix(prefix string, width int) {
+	m.write(prefix)
+	m.col += width
+	m.prefix, m.prefixWidth = prefix, m.col
+}
+
+// isRule reports whether t is a horizontal rule like --- or * * *.
+func isRule(t string) bool {
+	s := strings.ReplaceAll(t, " ", "")
+	return len(s) >= 3 && strings.Trim(s, s[:1]) == "" && strings.Contains("-*_", s[:1])
+}
+
+// listItem splits a list item into its marker, shown as a bullet for
+// unordered lists, and its text.
+func listItem(t string) (marker, rest string, ok bool) {
+	if len(t) >= 2 && strings.Contains("-*+", t[:1]) && t[1] == ' ' {
+		marker, rest = "•", t[2:]
+	} else {
+		digits := len(t) - len(strings.TrimLeft(t, "0123456789"))
+		if digits == 0 || digits > 9 || len(t) < digits+2 || !strings.Contains(".)", t[digits:digits+1]) || t[digits+1] != ' ' {
+			return "", "", false
+		}
+		marker, rest = t[:digits+1], t[digits+2:]
+	}
+	// Task lists
+	switch {
+	case strings.HasPrefix(rest, "[ ] "):
+		marker, rest = marker+" ☐", rest[4:]
+	case strings.HasPrefix(rest, "[x] "), strings.HasPrefix(rest, "[X] "):
+		marker, rest = marker+" ☑", rest[4:]
+	}
+	return marker, rest, true
+}
+
+// inline renders text of the current line word by word, ending the line
+// at eol.
+func (m *markdownWriter) inline(text string, eol bool) {
+	for _, r := range text {
+		if r != ' ' && r != '\t' {
+			m.word.WriteRune(r)
+			continue
+		}
+		if m.linkOpen() {
+			// Keep link texts whole so they can be rendered
+			m.word.WriteRune(' ')
+			continue
+		}
+		m.emitWord()
+		if m.rowHasWord {
+			m.space = true
+		}
+	}
+	if eol {
+		m.emitWord()
+		m.write("\n")
+		m.col, m.prefix, m.prefixWidth = 0, "", 0
+		m.space, m.rowHasWord = false, false
+		m.bold, m.italic, m.strike, m.code, m.heading = false, false, false, false, 0
+	}
+}
+
+// linkOpen reports whether the word being collected ends inside the text
+// of a [text](url) link.
+func (m *markdownWriter) linkOpen() bool {
+	w := m.word.String()
+	i := strings.LastIndex(w, "[")
+	if i < 0 || m.code || len(w)-i > 200 {
+		return false
+	}
+	rest := w[i:]
+	j := strings.Index(rest, "]")
+	if j < 0 {
+		return true
+	}
+	return j+1 < len(rest) && rest[j+1] == '(' && !strings.Contains(rest[j:], ")")
+}
+
+// emitWord writes the collected word, wrapping before it if it does not
+// fit the row.
+func (m *markdownWriter) emitWord() {
+	if m.word.Len() == 0 {
+		return
+	}
+	text, width := m.format(m.word.String())
+	m.word.Reset()
+	if m.space {
+		if m.col+1+width > m.width && m.col > m.prefixWidth {
+			m.write("\n" + m.prefix)
+			m.col = m.prefixWidth
+		} else {
+			m.write(" ")
+			m.col++
+		}
+		m.space = false
+	}
+	m.write(text)
+	m.col += width
+	if m.col > m.width {
+		// The terminal wrapped a word longer than the row
+		m.col %= m.width
+	}
+	m.rowHasWord = true
+}
+
+// format renders the inline markup of s, returning the text and its width
+// on screen. Emphasis may span words, so its state carries over to the
+// next call until the end of the line.
+func (m *markdownWriter) format(s string) (string, int) {
+	var b, seg strings.Builder
+	width := 0
+	flush := func() {
+		if seg.Len() > 0 {
+			b.WriteString(m.style().Sprint(seg.String()))
+			width += utf8.RuneCountInString(seg.String())
+			seg.Reset()
+		}
+	}
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		var next, prev rune
+		if i+1 < len(rs) {
+			next = rs[i+1]
+		}
+		if i > 0 {
+			prev = rs[i-1]
+		}
+
+		switch {
+		case r == '`':
+			flush()
+			m.code = !m.code
+		case m.code:
+			seg.WriteRune(r)
+		case r == '\\' && next != 0 && strings.ContainsRune("\\`*_[]()#+-.!~|<>", next):
+			seg.WriteRune(next)
+			i++
+		case (r == '*' || r == '_') && next == r:
+			flush()
+			m.bold = !m.bold
+			i++
+		case r == '*' || r == '_':
+			// A lone star, or an underscore inside a word like
+			// snake_case, is literal
+			opens := !m.italic && next != 0
+			closes := m.italic && prev != 0
+			if r == '_' && isWordRune(prev) && isWordRune(next) || !opens && !closes {
+				seg.WriteRune(r)
+				continue
+			}
+			flush()
+			m.italic = !m.italic
+		case r == '~' && next == '~':
+			flush()
+			m.strike = !m.strike
+			i++
+		case r == '[':
+			text, url, n, ok := parseLink(rs[i:])
+			if !ok {
+				seg.WriteRune(r)
+				continue
+			}
+			flush()
+			b.WriteString(m.style(color.Underline).Sprint(text))
+			width += utf8.RuneCountInString(text)
+			if url != text {
+				b.WriteString(noteColor(" (" + url + ")"))
+				width += utf8.RuneCountInString(url) + 3
+			}
+			i += n - 1
+		default:
+			seg.WriteRune(r)
+		}
+	}
+	flush()
+	return b.String(), width
+}
+
+// style returns the color of inline text in the current state.
+func (m *markdownWriter) style(extra ...color.Attribute) *color.Color {
+	attrs := []color.Attribute{color.FgCyan}
+	if m.code {
+		attrs = []color.Attribute{color.FgYellow}
+	}
+	if m.bold || m.heading > 0 {
+		attrs = append(attrs, color.Bold)
+	}
+	if m.heading == 1 {
+		attrs = append(attrs, color.Underline)
+	}
+	if m.italic {
+		attrs = append(attrs, color.Italic)
+	}
+	if m.strike {
+		attrs = append(attrs, color.CrossedOut)
+	}
+	return color.New(append(attrs, extra...)...)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parseLink parses a [text](url) link at the start of rs, returning its
+// length in runes.
+func parseLink(rs []rune) (text, url string, n int, ok bool) {
+	s := string(rs)
+	end := strings.Index(s, "](")
+	if end < 0 {
+		return "", "", 0, false
+	}
+	length := strings.Index(s[end:], ")")
+	if length < 0 {
+		return "", "", 0, false
+	}
+	text, url = s[1:end], s[end+2:end+length]
+	return text, url, utf8.RuneCountInString(s[:end+length+1]), true
+}
+
+// flushTable renders the buffered table rows with aligned columns, or as
+// they are if the table is wider than the terminal.
+func (m *markdownWriter) flushTable() {
+	rows := m.table
+	m.table = nil
+
+	var cells [][]string
+	for _, row := range rows {
+		row = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(row), "|"), "|")
+		parts := strings.Split(row, "|")
+		rule := true
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+			if strings.Trim(parts[i], ":-") != "" || parts[i] == "" {
+				rule = false
+			}
+		}
+		if !rule {
+			cells = append(cells, parts)
+		}
+	}
+
+	// Format every cell to learn the column widths
+	var texts [][]string
+	var widths [][]int
+	var colWidths []int
+	for r, row := range cells {
+		texts = append(texts, make([]string, len(row)))
+		widths = append(widths, make([]int, len(row)))
+		for c, cell := range row {
+			m.bold, m.italic, m.strike, m.code = r == 0, false, false, false
+			texts[r][c], widths[r][c] = m.format(cell)
+			if c == len(colWidths) {
+				colWidths = append(colWidths, 0)
+			}
+			colWidths[c] = max(colWidths[c], widths[r][c])
+		}
+	}
+	m.bold, m.italic, m.strike, m.code = false, false, false, false
+
+	total := 0
+	for _, w := range colWidths {
+		total += w + 3
+	}
+	m.block()
+	if total-3 > m.width {
+		for _, row := range rows {
+			m.write(aiColor(row) + "\n")
+		}
+		return
+	}
+
+	for r := range texts {
+		var line strings.Builder
+		for c, text := range texts[r] {
+			if c > 0 {
+				line.WriteString(noteColor(" │ "))
+			}
+			line.WriteString(text)
+			if c < len(texts[r])-1 {
+				line.WriteString(strings.Repeat(" ", colWidths[c]-widths[r][c]))
+			}
+		}
+		m.write(line.String() + "\n")
+		if r == 0 {
+			rules := make([]string, len(colWidths))
+			for c, w := range colWidths {
+				rules[c] = strings.Repeat("─", w)
+			}
+			m.write(noteColor(strings.Join(rules, "─┼─")) + "\n")
+		}
+	}
+}
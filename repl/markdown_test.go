@@ -0,0 +1,215 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// document exercises every block and inline element the renderer knows.
+const document = "# Title with *emphasis*\n" +
+	"\n" +
+	"A paragraph with **bold**, _italic_, ~~struck~~ and `code` text, a snake_case name and a [link](https://example.com) that is long enough to wrap at least once.\n" +
+	"Escaped \\*stars\\* stay literal.\n" +
+	"\n" +
+	"## Lists\n" +
+	"\n" +
+	"- first item\n" +
+	"* second item\n" +
+	"  - nested item\n" +
+	"1. numbered\n" +
+	"10) other numbering\n" +
+	"- [ ] open task\n" +
+	"- [x] done task\n" +
+	"\n" +
+	"> a quote that goes on for a while so that it wraps onto a second row of the terminal\n" +
+	"\n" +
+	"---\n" +
+	"\n" +
+	"| Name | Count |\n" +
+	"|------|------:|\n" +
+	"| apples | 3 |\n" +
+	"| **pears** | 12 |\n" +
+	"\n" +
+	"```go\n" +
+	"func main() { // comment\n" +
+	"\ts := \"a \\\"quoted\\\" string\" /* block\n" +
+	"\tcomment */ n := 42\n" +
+	"}\n" +
+	"```\n" +
+	"~~~sql\n" +
+	"SELECT * FROM t WHERE id = 'x' -- note\n" +
+	"~~~\n" +
+	"\n" +
+	"Ünïcödé wörds — and emoji 🎉 at the end\r\n" +
+	"    indented paragraph\n" +
+	"####### not a heading\n" +
+	"#hashtag"
+
+// render returns doc rendered from the given chunks.
+func render(chunks []string) string {
+	var out bytes.Buffer
+	m := newMarkdownWriter(&out, 40, len("AI: "))
+	for _, chunk := range chunks {
+		m.WriteString(chunk)
+	}
+	m.Flush()
+	return out.String()
+}
+
+// chunkings splits doc the ways a stream may deliver it.
+func chunkings(doc string) map[string][]string {
+	var runes, pairs, words, lines []string
+	for _, r := range doc {
+		runes = append(runes, string(r))
+	}
+	for i := 0; i < len(runes); i += 2 {
+		pairs = append(pairs, strings.Join(runes[i:min(i+2, len(runes))], ""))
+	}
+	words = strings.SplitAfter(doc, " ")
+	lines = strings.SplitAfter(doc, "\n")
+
+	// Split right after each newline and right before it
+	var aroundNewlines []string
+	for _, line := range lines {
+		if body, ok := strings.CutSuffix(line, "\n"); ok {
+			aroundNewlines = append(aroundNewlines, body, "\n")
+		} else {
+			aroundNewlines = append(aroundNewlines, line)
+		}
+	}
+
+	// Chunks of varying sizes that keep runes whole
+	var uneven []string
+	for i, n := 0, 1; i < len(runes); n = n%7 + 1 {
+		uneven = append(uneven, strings.Join(runes[i:min(i+n, len(runes))], ""))
+		i += n
+	}
+
+	return map[string][]string{
+		"runes":          runes,
+		"rune pairs":     pairs,
+		"words":          words,
+		"lines":          lines,
+		"around newline": aroundNewlines,
+		"uneven":         uneven,
+	}
+}
+
+func TestMarkdownChunking(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+
+	docs := map[string]string{
+		"document":       document,
+		"no final break": "Some *text* without a newline",
+		"open fence":     "```python\ndef f():\n    return 'x'",
+		"table at end":   "| a | b |\n|---|---|\n| 1 | 2 |",
+		"rule prefixes":  "--\n-- not a rule\n***\n1.\n12. item\n#\n# heading",
+	}
+	for name, doc := range docs {
+		want := render([]string{doc})
+		for split, chunks := range chunkings(doc) {
+			if got := render(chunks); got != want {
+				t.Errorf("%s split into %s renders\n%q\nwant\n%q", name, split, got, want)
+			}
+		}
+	}
+}
+
+func TestMarkdownOutput(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"paragraph", "Hello **world**", "Hello world\n"},
+		{"wrapping", "one two three four five six seven eight nine ten", "one two three four five six seven\neight nine ten\n"},
+		{"heading", "intro\n## Heading", "intro\nHeading\n"},
+		{"list", "- a\n- [x] b\n3. c", "\n• a\n• ☑ b\n3. c\n"},
+		{"quote", "> quoted", "│ quoted\n"},
+		{"escapes", `\*not italic\* snake_case`, "*not italic* snake_case\n"},
+		{"link", "see [docs](https://x.io)", "see docs (https://x.io)\n"},
+		{"code block", "```\n\tx := 1\n```", "\n  " + "    x := 1\n"},
+		{"table", "| a | bb |\n|---|---|\n| ccc | d |", "\na   │ bb\n────┼───\nccc │ d\n"},
+		{"paragraphs", "one\n\ntwo", "one\n\ntwo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render([]string{tt.doc}); got != tt.want {
+				t.Errorf("render(%q) =\n%q\nwant\n%q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+
+	tests := []struct {
+		lang string
+		line string
+		want string
+	}{
+		{"go", "return nil", keywordColor("return") + " " + keywordColor("nil")},
+		{"go", `s := "a \" b"`, "s := " + stringColor(`"a \" b"`)},
+		{"go", "x := 42 // answer", "x := " + numberColor("42") + " " + commentColor("// answer")},
+		{"go", "v2 := x", "v2 := x"},
+		{"python", "x = 1 # note", "x = " + numberColor("1") + " " + commentColor("# note")},
+		{"python", "url#anchor", "url#anchor"},
+		{"SQL", "select Name", keywordColor("select") + " Name"},
+		{"unknown", "if 'x'", "if " + stringColor("'x'")},
+		{"go", `"unterminated`, stringColor(`"unterminated`)},
+		{"go", `"trailing \`, stringColor(`"trailing \`)},
+	}
+	for _, tt := range tests {
+		m := &markdownWriter{lang: lookupSyntax(tt.lang)}
+		if got := m.highlight(tt.line); got != tt.want {
+			t.Errorf("highlight(%s, %q) = %q, want %q", tt.lang, tt.line, got, tt.want)
+		}
+	}
+
+	// Block comments carry over to the following lines
+	m := &markdownWriter{lang: lookupSyntax("go")}
+	lines := []string{"a /* open", "still comment", "end */ b"}
+	want := []string{"a " + commentColor("/*") + commentColor(" open"), commentColor("still comment"), commentColor("end */") + " b"}
+	for i, line := range lines {
+		if got := m.highlight(line); got != want[i] {
+			t.Errorf("line %d: highlight(%q) = %q, want %q", i, line, got, want[i])
+		}
+	}
+}
+
+func FuzzMarkdownWriter(f *testing.F) {
+	f.Add(document, uint8(1))
+	f.Add("```go\n/* x", uint8(3))
+	f.Add("| a |\n|-|\n| [b](c", uint8(2))
+	f.Add("**_~~`x", uint8(0))
+	f.Add(`"\`, uint8(1))
+	f.Fuzz(func(t *testing.T, doc string, size uint8) {
+		if !utf8.ValidString(doc) {
+			// Streams deliver decoded text, never partial runes
+			t.Skip()
+		}
+		var chunks []string
+		rs := []rune(doc)
+		n := int(size)%16 + 1
+		for i := 0; i < len(rs); i += n {
+			chunks = append(chunks, string(rs[i:min(i+n, len(rs))]))
+		}
+		render(chunks)
+		for _, lang := range []string{"go", "python", "sql", "x"} {
+			m := &markdownWriter{lang: lookupSyntax(lang)}
+			for _, line := range strings.Split(doc, "\n") {
+				m.highlight(line)
+			}
+		}
+	})
+}
@@ -0,0 +1,279 @@
+// Package repl is the interactive terminal chat: a read-eval-print loop
+// over an llm.Client whose slash commands other packages can extend with
+// Register.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"askgo/attach"
+	"askgo/llm"
+)
+
+// Colors of the chat transcript.
+var (
+	userColor = color.New(color.FgGreen).SprintFunc()
+	aiColor   = color.New(color.FgCyan).SprintFunc()
+	errColor  = color.New(color.FgRed).SprintFunc()
+	noteColor = color.New(color.Faint).SprintFunc()
+)
+
+// TranscriptFile is where Session.Transcript appends each exchange.
+const TranscriptFile = "conversation.txt"
+
+// Session is one interactive conversation. Commands may change any field
+// between turns.
+type Session struct {
+	Client  *llm.Client
+	Options llm.Options
+	// System is the system prompt opening every request, if any.
+	System string
+	// History holds the user and assistant messages so far.
+	History []llm.Message
+	// Attach holds files and directories to attach to the next prompt.
+	Attach []string
+	// Transcript appends every exchange to TranscriptFile.
+	Transcript bool
+	// Raw prints answers as the model wrote them instead of rendering
+	// their markdown.
+	Raw bool
+	// InputHistory is the file keeping the prompts typed in a terminal
+	// between sessions; empty keeps them in memory only.
+	InputHistory string
+	Out          io.Writer
+}
+
+// NewSession returns a session on client writing to stdout and keeping
+// its input history in DefaultHistoryFile.
+func NewSession(client *llm.Client, opts llm.Options, system string) *Session {
+	return &Session{Client: client, Options: opts, System: system, InputHistory: DefaultHistoryFile(), Out: os.Stdout}
+}
+
+// Messages returns the request for the current history: the system prompt
+// followed by History.
+func (s *Session) Messages() []llm.Message {
+	var messages []llm.Message
+	if s.System != "" {
+		messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: s.System})
+	}
+	return append(messages, s.History...)
+}
+
+// Target returns the backend and model answering in the session.
+func (s *Session) Target() llm.Target {
+	t := llm.Target{Provider: s.Options.Provider, Model: s.Options.Model}
+	if t.Provider == "" {
+		t.Provider = s.Client.Provider()
+	}
+	if t.Model == "" {
+		t.Model = s.Client.ProviderModel(t.Provider)
+	}
+	return t
+}
+
+// Printf writes a line of command output.
+func (s *Session) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(s.Out, format+"\n", args...)
+}
+
+// Notef writes a dimmed line of status output.
+func (s *Session) Notef(format string, args ...interface{}) {
+	fmt.Fprintln(s.Out, noteColor(fmt.Sprintf(format, args...)))
+}
+
+// Errorf writes an error line.
+func (s *Session) Errorf(format string, args ...interface{}) {
+	fmt.Fprintln(s.Out, errColor(fmt.Sprintf(format, args...)))
+}
+
+// multilineQuote starts and ends a prompt spanning several lines.
+const multilineQuote = `"""`
+
+// Run reads prompts from in until "exit", "quit" or end of input. Lines
+// starting with a slash run commands; anything else is sent to the model.
+// A line starting with """ continues until one ending with """. A terminal
+// gets line editing with history and tab completion.
+func (s *Session) Run(in io.Reader) error {
+	lines := newLineReader(in, s.Out, s.complete, s.InputHistory)
+	defer lines.Close()
+
+	for {
+		prompt, err := readPrompt(lines)
+		if err == io.EOF {
+			fmt.Fprintln(s.Out)
+			return nil
+		} else if errors.Is(err, errInterrupted) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("reading input: %w", err)
+		}
+		prompt = strings.TrimSpace(prompt)
+		lines.AddHistory(prompt)
+
+		switch {
+		case prompt == "":
+			continue
+		case prompt == "exit" || prompt == "quit":
+			return nil
+		case strings.HasPrefix(prompt, "/"):
+			if err := s.Execute(prompt); errors.Is(err, ErrExit) {
+				return nil
+			} else if err != nil {
+				s.Errorf("Error: %v", err)
+			}
+		default:
+			s.Send(prompt)
+		}
+	}
+}
+
+// readPrompt reads a prompt, joining the lines between triple quotes.
+func readPrompt(lines lineReader) (string, error) {
+	line, err := lines.ReadLine(userColor("You: "))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(line), multilineQuote) {
+		return line, err
+	}
+
+	text := strings.TrimPrefix(strings.TrimSpace(line), multilineQuote)
+	for !strings.HasSuffix(text, multilineQuote) {
+		line, err := lines.ReadLine(userColor("... "))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		text += "\n" + line
+	}
+	return strings.TrimSuffix(text, multilineQuote), nil
+}
+
+// Send sends prompt with the files of s.Attach and those it references as
+// @path, and streams the model's answer. On failure the prompt is dropped
+// again so the history stays well-formed, and false is returned.
+func (s *Session) Send(prompt string) bool {
+	paths := append(s.Attach, attach.References(prompt)...)
+	if len(paths) == 0 {
+		return s.send(prompt)
+	}
+
+	t := s.Target()
+	set := attach.NewSet(attach.Budget(s.Client.ContextWindow(t.Provider, t.Model)))
+	for _, path := range paths {
+		if err := set.Add(path); err != nil {
+			s.Errorf("Error attaching %s: %v", path, err)
+		}
+	}
+	for _, f := range set.Files {
+		s.Notef("Attached %s (%d tokens)", f.Path, f.Tokens)
+	}
+	for _, skipped := range set.Skipped {
+		s.Notef("Skipped %s: %s", skipped.Path, skipped.Reason)
+	}
+
+	pending := s.Attach
+	s.Attach = nil
+	if !s.send(set.Prompt(prompt)) {
+		// Keep the files for the next try
+		s.Attach = pending
+		return false
+	}
+	return true
+}
+
+// send adds content to the history and streams the answer to it.
+func (s *Session) send(content string) bool {
+	s.History = append(s.History, llm.Message{Role: llm.RoleUser, Content: content})
+
+	// Print the answer as it streams in
+	fmt.Fprint(s.Out, aiColor("AI: "))
+	var md *markdownWriter
+	if !s.Raw {
+		md = newMarkdownWriter(s.Out, s.width(), len("AI: "))
+	}
+	resp, err := s.Client.ChatStream(context.Background(), s.Messages(), s.Options, func(delta string) error {
+		if md != nil {
+			md.WriteString(delta)
+		} else {
+			fmt.Fprint(s.Out, aiColor(delta))
+		}
+		return nil
+	})
+	if md != nil {
+		md.Flush()
+	} else {
+		fmt.Fprintln(s.Out)
+	}
+	if err != nil {
+		s.Errorf("Error: %s", ErrorText(err))
+		s.History = s.History[:len(s.History)-1]
+		return false
+	}
+
+	if resp.Truncated > 0 {
+		s.Notef("(%d older messages did not fit the context window of %s and were left out)", resp.Truncated, resp.Model)
+	}
+	s.History = append(s.History, resp.Message)
+
+	if s.Transcript {
+		exchange := s.History[len(s.History)-2:]
+		if len(s.History) == 2 && s.System != "" {
+			exchange = s.Messages()
+		}
+		if err := appendTranscript(exchange); err != nil {
+			s.Errorf("Error saving conversation: %v", err)
+		}
+	}
+	return true
+}
+
+// width returns the width of the terminal the session prints to.
+func (s *Session) width() int {
+	if f, ok := s.Out.(*os.File); ok {
+		return terminalWidth(int(f.Fd()))
+	}
+	return 80
+}
+
+// ErrorText is the message shown for a failed request: the friendly text
+// followed by the backend's request ID, which users can quote in reports.
+func ErrorText(err error) string {
+	text := llm.UserMessage(err)
+	if id := llm.RequestID(err); id != "" {
+		text += " (request " + id + ")"
+	}
+	return text
+}
+
+// appendTranscript appends messages to TranscriptFile.
+func appendTranscript(messages []llm.Message) error {
+	file, err := os.OpenFile(TranscriptFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, message := range messages {
+		w.WriteString(transcriptLine(message) + "\n")
+	}
+	return w.Flush()
+}
+
+// transcriptLine renders a message the way it is shown to the user.
+func transcriptLine(message llm.Message) string {
+	switch message.Role {
+	case llm.RoleUser:
+		return "You: " + message.Content
+	case llm.RoleSystem:
+		return "System: " + message.Content
+	}
+	return "AI: " + message.Content
+}
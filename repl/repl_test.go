@@ -0,0 +1,126 @@
+package repl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"askgo/llm"
+	"askgo/llm/llmtest"
+)
+
+// newTestSession returns a session on a fake backend answering with
+// replies, writing raw answers to a buffer.
+func newTestSession(replies ...llmtest.Reply) (*Session, *llmtest.FakeProvider, *bytes.Buffer) {
+	fake := llmtest.NewFakeProvider(replies...)
+	out := &bytes.Buffer{}
+	return &Session{Client: llmtest.NewClient(fake), System: "Be brief.", Raw: true, Out: out}, fake, out
+}
+
+func TestRun(t *testing.T) {
+	s, fake, out := newTestSession(
+		llmtest.Reply{Content: "Hello there"},
+		llmtest.Reply{Content: "Two lines noted"},
+	)
+	input := strings.Join([]string{
+		"hi",
+		"",
+		`"""first`,
+		`second"""`,
+		"/history",
+		"exit",
+		"never sent",
+	}, "\n")
+
+	if err := s.Run(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+	first := requests[0].Messages
+	if len(first) != 2 || first[0].Role != llm.RoleSystem || first[1].Content != "hi" {
+		t.Errorf("first request = %+v, want the system prompt and the prompt", first)
+	}
+	if got := requests[1].Messages; len(got) != 4 || got[3].Content != "first\nsecond" {
+		t.Errorf("second request = %+v, want the history and the joined multiline prompt", got)
+	}
+	if len(s.History) != 4 || s.History[3].Content != "Two lines noted" {
+		t.Errorf("history = %+v, want both exchanges", s.History)
+	}
+	for _, want := range []string{"AI: Hello there", "You: first\nsecond", "AI: Two lines noted"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunEndsAtEOF(t *testing.T) {
+	for _, input := range []string{"", "/new", `"""unterminated`} {
+		s, _, _ := newTestSession()
+		if err := s.Run(strings.NewReader(input)); err != nil {
+			t.Errorf("Run(%q) = %v, want nil at end of input", input, err)
+		}
+	}
+}
+
+func TestRunExitCommand(t *testing.T) {
+	s, fake, _ := newTestSession(llmtest.Reply{Content: "unused"})
+	if err := s.Run(strings.NewReader("/exit\nhi\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests()); n != 0 {
+		t.Errorf("sent %d requests after /exit", n)
+	}
+}
+
+func TestRunFailedSend(t *testing.T) {
+	s, fake, out := newTestSession(
+		llmtest.Reply{Content: "one two three", Err: errors.New("connection reset"), FailAfter: 1},
+		llmtest.Reply{Content: "Recovered"},
+	)
+	if err := s.Run(strings.NewReader("hi\nhi again\n/nosuch\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed prompt is dropped, so the next request holds only the retry
+	if got := fake.Requests()[1].Messages; len(got) != 2 || got[1].Content != "hi again" {
+		t.Errorf("request after the failure = %+v", got)
+	}
+	if len(s.History) != 2 || s.History[1].Content != "Recovered" {
+		t.Errorf("history = %+v, want only the successful exchange", s.History)
+	}
+	for _, want := range []string{"Error: ", "nosuch"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRetryAndUndo(t *testing.T) {
+	s, fake, _ := newTestSession(
+		llmtest.Reply{Content: "first answer"},
+		llmtest.Reply{Err: errors.New("down")},
+		llmtest.Reply{Content: "second answer"},
+	)
+	if err := s.Run(strings.NewReader("question\n/retry\n/retry\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests()); n != 3 {
+		t.Fatalf("sent %d requests, want 3", n)
+	}
+	// A failed retry keeps the previous answer; a good one replaces it
+	if len(s.History) != 2 || s.History[1].Content != "second answer" {
+		t.Errorf("history = %+v, want the question and the second answer", s.History)
+	}
+
+	if err := s.Execute("/undo"); err != nil || len(s.History) != 0 {
+		t.Errorf("/undo = %v, history %+v, want it empty", err, s.History)
+	}
+	if err := s.Execute("/undo"); err == nil {
+		t.Error("/undo on an empty history succeeded")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package repl
+
+import "errors"
+
+// Line editing needs termios; elsewhere input is read line by line.
+
+type termState struct{}
+
+func isTerminal(int) bool { return false }
+
+func makeRaw(int) (*termState, error) {
+	return nil, errors.New("repl: raw terminal mode not supported")
+}
+
+func restoreTerminal(int, *termState) error { return nil }
+
+func terminalWidth(int) int { return 80 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"askgo/database"
+	"askgo/llm"
+)
+
+// runUsage prints the token usage recorded by the web server and its
+// estimated cost, for one month by default.
+func runUsage(cfg llm.Config, args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	month := fs.String("month", time.Now().UTC().Format("2006-01"), "Month to report, as YYYY-MM")
+	from := fs.String("from", "", "First day to report, as YYYY-MM-DD (overrides -month)")
+	to := fs.String("to", "", "Last day to report, as YYYY-MM-DD (overrides -month)")
+	email := fs.String("user", "", "Only report the user with this email")
+	by := fs.String("by", "model", "Group by day, model or user")
+	fs.Parse(args)
+
+	filter, err := usageRange(*month, *from, *to)
+	if err != nil {
+		return err
+	}
+	if *by != "day" && *by != "model" && *by != "user" {
+		return fmt.Errorf("-by must be day, model or user")
+	}
+
+	if err := database.InitDB(); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	defer database.CloseDB()
+
+	if *email != "" {
+		user, err := database.GetUserByEmail(*email)
+		if err != nil {
+			return fmt.Errorf("no user with email %s", *email)
+		}
+		filter.UserID = user.ID
+	}
+
+	records, err := database.ListUsage(filter)
+	if err != nil {
+		return err
+	}
+
+	// Users are shown by email, looked up once each
+	emails := make(map[string]string)
+	key := func(r database.UsageRecord) string {
+		switch *by {
+		case "day":
+			return r.Day
+		case "user":
+			id := r.UserID.Hex()
+			if _, ok := emails[id]; !ok {
+				emails[id] = id
+				if user, err := database.GetUserByID(r.UserID); err == nil {
+					emails[id] = user.Email
+				}
+			}
+			return emails[id]
+		default:
+			return r.Provider + ":" + r.Model
+		}
+	}
+
+	client := llm.NewClient(cfg)
+	cost := func(r database.UsageRecord) (float64, bool) {
+		return client.Cost(r.Provider, r.Model, r.Usage())
+	}
+	fmt.Printf("Usage from %s to %s\n\n", filter.From, filter.To)
+	printUsage(os.Stdout, database.TotalUsage(records, key, cost), database.TotalUsage(records, func(database.UsageRecord) string { return "total" }, cost))
+	return nil
+}
+
+// usageRange turns the -month, -from and -to flags into a filter.
+func usageRange(month, from, to string) (database.UsageFilter, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return database.UsageFilter{}, fmt.Errorf("-month must look like 2006-01")
+	}
+	filter := database.UsageFilter{
+		From: start.Format(database.DayFormat),
+		To:   start.AddDate(0, 1, -1).Format(database.DayFormat),
+	}
+	for _, day := range []struct {
+		flag, value string
+		dest        *string
+	}{{"-from", from, &filter.From}, {"-to", to, &filter.To}} {
+		if day.value == "" {
+			continue
+		}
+		if _, err := time.Parse(database.DayFormat, day.value); err != nil {
+			return database.UsageFilter{}, fmt.Errorf("%s must look like 2006-01-02", day.flag)
+		}
+		*day.dest = day.value
+	}
+	return filter, nil
+}
+
+// printUsage writes totals as a table followed by the grand total.
+func printUsage(out io.Writer, totals, grand []database.UsageTotal) {
+	fmt.Fprintf(out, "%-40s %9s %12s %12s %12s %11s\n", "", "Requests", "Prompt", "Completion", "Total", "Cost")
+	unpriced := false
+	for _, t := range append(totals, grand...) {
+		marker := ""
+		if t.Unpriced {
+			marker, unpriced = "*", true
+		}
+		fmt.Fprintf(out, "%-40s %9d %12d %12d %12d %10s%1s\n", t.Key, t.Requests,
+			t.Usage.PromptTokens, t.Usage.CompletionTokens, t.Usage.TotalTokens, fmt.Sprintf("$%.4f", t.Cost), marker)
+	}
+	if unpriced {
+		fmt.Fprintln(out, "\n* includes models without a known price, which are not counted in the cost")
+	}
+}
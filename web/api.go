@@ -0,0 +1,803 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"askgo/attach"
+	"askgo/database"
+	"askgo/llm"
+	"askgo/persona"
+)
+
+// apiPrefix is the root of the versioned JSON API.
+const apiPrefix = "/api/v1/conversations"
+
+// maxMessageBytes caps the body of a message, attachments included.
+const maxMessageBytes = 10 << 20
+
+// apiError is the body of every non-2xx API response.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID is the upstream request ID of a failed model call.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiConversation struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Provider and Model are empty while the conversation uses the server
+	// default.
+	Provider  string        `json:"provider,omitempty"`
+	Model     string        `json:"model,omitempty"`
+	Params    llm.Params    `json:"params"`
+	Persona   string        `json:"persona,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Messages  []llm.Message `json:"messages,omitempty"`
+	// Summary is set once the oldest messages have been summarized.
+	Summary *llm.Summary `json:"summary,omitempty"`
+	// Usage sums the tokens of the replies in Messages.
+	Usage *llm.Usage `json:"usage,omitempty"`
+}
+
+type apiConversationList struct {
+	Conversations []apiConversation `json:"conversations"`
+	HasMore       bool              `json:"has_more"`
+}
+
+type apiMessageList struct {
+	ConversationID string        `json:"conversation_id"`
+	Messages       []llm.Message `json:"messages"`
+}
+
+type apiMessageRequest struct {
+	Content string `json:"content"`
+	// Provider and Model optionally override the conversation's backend
+	// for this message.
+	Provider string     `json:"provider,omitempty"`
+	Model    string     `json:"model,omitempty"`
+	Params   llm.Params `json:"params"`
+	// Attachments are files whose contents are sent along with Content.
+	Attachments []apiAttachment `json:"attachments,omitempty"`
+}
+
+// apiAttachment is an uploaded file; Data is base64 in JSON.
+type apiAttachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type apiMessageResponse struct {
+	ConversationID string `json:"conversation_id"`
+	// Index is the position of Message in the conversation.
+	Index    int         `json:"index"`
+	Message  llm.Message `json:"message"`
+	Model    string      `json:"model"`
+	Provider string      `json:"provider"`
+	Usage    llm.Usage   `json:"usage"`
+	// Attached and Skipped list the attachments sent and left out.
+	Attached []attach.File    `json:"attached,omitempty"`
+	Skipped  []attach.Skipped `json:"skipped,omitempty"`
+}
+
+// apiConversationRequest is the body of create and update requests.
+// Fields left out of an update keep their value.
+type apiConversationRequest struct {
+	Title    *string `json:"title"`
+	Provider *string `json:"provider"`
+	Model    *string `json:"model"`
+	// Params replace the conversation's sampling parameters as a whole.
+	Params *llm.Params `json:"params"`
+	// Persona names the conversation's persona, empty for none.
+	Persona *string `json:"persona"`
+}
+
+type apiModel struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// ContextWindow is the model's context length in tokens.
+	ContextWindow int `json:"context_window"`
+	// Default marks the model used by conversations without a choice.
+	Default bool `json:"default"`
+}
+
+type apiModelList struct {
+	Models []apiModel `json:"models"`
+}
+
+type apiPersona struct {
+	persona.Persona
+	// Source is "user" for the caller's own personas and "server" for
+	// shared ones.
+	Source string `json:"source"`
+}
+
+type apiKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// Key is only returned when the key is created.
+	Key string `json:"key,omitempty"`
+}
+
+type apiKeyRequest struct {
+	Name string `json:"name"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// upstreamStatus maps a failed model call to the status and error code
+// reported to API clients.
+func upstreamStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, llm.ErrUnknownProvider):
+		return http.StatusBadRequest, "bad_request"
+	case errors.Is(err, llm.ErrRateLimited):
+		return http.StatusTooManyRequests, "rate_limited"
+	case errors.Is(err, llm.ErrContextLength):
+		return http.StatusBadRequest, "context_length_exceeded"
+	case errors.Is(err, llm.ErrModelUnavailable):
+		return http.StatusBadGateway, "model_unavailable"
+	case errors.Is(err, llm.ErrBadRequest):
+		return http.StatusBadRequest, "upstream_rejected"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "upstream_timeout"
+	default:
+		// Authentication failures are our misconfiguration, not the
+		// client's, so they are reported as a bad gateway too.
+		return http.StatusBadGateway, "upstream_error"
+	}
+}
+
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status, code := upstreamStatus(err)
+	writeJSON(w, status, apiError{Error: apiErrorDetail{
+		Code:      code,
+		Message:   llm.UserMessage(err),
+		RequestID: llm.RequestID(err),
+	}})
+}
+
+func toAPIConversation(c database.Conversation) apiConversation {
+	return apiConversation{
+		ID:        c.ID.Hex(),
+		Title:     c.Title,
+		Provider:  c.Provider,
+		Model:     c.Model,
+		Params:    c.Params,
+		Persona:   c.Persona,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Messages:  c.Messages,
+		Summary:   apiSummary(c.Summary),
+		Usage:     conversationUsage(c.Messages),
+	}
+}
+
+// conversationUsage sums the usage recorded on messages, nil when there is
+// none.
+func conversationUsage(messages []llm.Message) *llm.Usage {
+	var total llm.Usage
+	for _, m := range messages {
+		if m.Usage != nil {
+			total = total.Add(*m.Usage)
+		}
+	}
+	if total == (llm.Usage{}) {
+		return nil
+	}
+	return &total
+}
+
+// apiSummary returns s, or nil for conversations never summarized.
+func apiSummary(s llm.Summary) *llm.Summary {
+	if s.Content == "" {
+		return nil
+	}
+	return &s
+}
+
+// handleAPIConversations routes everything under /api/v1/conversations:
+//
+//	GET    /api/v1/conversations                 list, newest first
+//	POST   /api/v1/conversations                 create
+//	GET    /api/v1/conversations/{id}            fetch with messages
+//	PATCH  /api/v1/conversations/{id}            rename or change model
+//	DELETE /api/v1/conversations/{id}            delete
+//	GET    /api/v1/conversations/{id}/messages   list messages
+//	POST   /api/v1/conversations/{id}/messages   send a message
+func handleAPIConversations(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	switch {
+	case parts[0] == "":
+		switch r.Method {
+		case http.MethodGet:
+			apiListConversations(w, r, user)
+		case http.MethodPost:
+			apiCreateConversation(w, r, user)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			apiGetConversation(w, r, user, parts[0])
+		case http.MethodPatch:
+			apiUpdateConversation(w, r, user, parts[0])
+		case http.MethodDelete:
+			apiDeleteConversation(w, r, user, parts[0])
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		}
+	case len(parts) == 2 && parts[1] == "messages":
+		switch r.Method {
+		case http.MethodGet:
+			apiListMessages(w, r, user, parts[0])
+		case http.MethodPost:
+			apiSendMessage(w, r, user, parts[0])
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", "Unknown endpoint")
+	}
+}
+
+// apiLoadConversation resolves id for user, writing an error response and
+// returning nil when it cannot be loaded.
+func apiLoadConversation(w http.ResponseWriter, user *database.User, id string) *conversation {
+	conv, err := loadConversation(user.ID, id)
+	if err == database.ErrConversationNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Conversation not found")
+		return nil
+	} else if err != nil {
+		logger.Println("Error loading conversation:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading conversation")
+		return nil
+	}
+	return conv
+}
+
+func apiListConversations(w http.ResponseWriter, r *http.Request, user *database.User) {
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	if limit <= 0 || limit > 100 {
+		limit = conversationsPerPage
+	}
+
+	list, err := db.ListConversations(user.ID, offset, limit+1)
+	if err != nil {
+		logger.Println("Error listing conversations:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error listing conversations")
+		return
+	}
+
+	result := apiConversationList{Conversations: []apiConversation{}}
+	if int64(len(list)) > limit {
+		list, result.HasMore = list[:limit], true
+	}
+	for _, c := range list {
+		result.Conversations = append(result.Conversations, toAPIConversation(c))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func apiCreateConversation(w http.ResponseWriter, r *http.Request, user *database.User) {
+	var body apiConversationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+			return
+		}
+	}
+	provider, model := stringValue(body.Provider), stringValue(body.Model)
+	if !apiCheckModel(w, r, provider, model) || !apiCheckParams(w, body.Params) || !apiCheckPersona(w, user, body.Persona) {
+		return
+	}
+
+	// Store the settings with the conversation so a failure leaves no
+	// half-configured one behind
+	conversation := database.Conversation{
+		UserID:   user.ID,
+		Title:    strings.TrimSpace(stringValue(body.Title)),
+		Provider: provider,
+		Model:    model,
+		Persona:  stringValue(body.Persona),
+	}
+	if body.Params != nil {
+		conversation.Params = *body.Params
+	}
+	created, err := db.InsertConversation(conversation)
+	if err != nil {
+		logger.Println("Error creating conversation:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error creating conversation")
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAPIConversation(*created))
+}
+
+// apiCheckModel validates a provider and model choice, writing an error
+// response when no configured backend serves it. Empty values stand for
+// the defaults and are always valid.
+func apiCheckModel(w http.ResponseWriter, r *http.Request, provider, model string) bool {
+	if provider == "" && model == "" {
+		return true
+	}
+	err := llmClient.CheckModel(r.Context(), llm.Target{Provider: provider, Model: model})
+	if errors.Is(err, llm.ErrUnknownProvider) {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Unknown provider")
+		return false
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Unknown model")
+		return false
+	}
+	return true
+}
+
+// apiCheckPersona validates a persona choice, writing an error response
+// when user has no persona of that name.
+func apiCheckPersona(w http.ResponseWriter, user *database.User, name *string) bool {
+	if name == nil || *name == "" {
+		return true
+	}
+	_, err := findPersona(user.ID, *name)
+	if errors.Is(err, persona.ErrNotFound) {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Unknown persona")
+		return false
+	} else if err != nil {
+		logger.Println("Error loading persona:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading persona")
+		return false
+	}
+	return true
+}
+
+// apiCheckParams validates sampling parameters, writing an error response
+// when one is out of range.
+func apiCheckParams(w http.ResponseWriter, params *llm.Params) bool {
+	if params == nil {
+		return true
+	}
+	if err := params.Validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return false
+	}
+	return true
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func apiGetConversation(w http.ResponseWriter, r *http.Request, user *database.User, id string) {
+	conv := apiLoadConversation(w, user, id)
+	if conv == nil {
+		return
+	}
+
+	stored, err := db.GetConversation(user.ID, conv.id)
+	if err != nil {
+		logger.Println("Error loading conversation:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading conversation")
+		return
+	}
+	result := toAPIConversation(*stored)
+	result.Messages = conv.snapshot()
+	writeJSON(w, http.StatusOK, result)
+}
+
+func apiUpdateConversation(w http.ResponseWriter, r *http.Request, user *database.User, id string) {
+	var body apiConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+		return
+	}
+	if body.Title == nil && body.Provider == nil && body.Model == nil && body.Params == nil && body.Persona == nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Nothing to update")
+		return
+	}
+	title := strings.TrimSpace(stringValue(body.Title))
+	if body.Title != nil && title == "" {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Title is required")
+		return
+	}
+	if !apiCheckParams(w, body.Params) || !apiCheckPersona(w, user, body.Persona) {
+		return
+	}
+
+	conv := apiLoadConversation(w, user, id)
+	if conv == nil {
+		return
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	// Validate the model before anything is written; a model alone is
+	// resolved against the conversation's provider
+	provider, model := conv.provider, conv.model
+	if body.Provider != nil {
+		// A new provider starts from its default model
+		provider, model = *body.Provider, ""
+	}
+	if body.Model != nil {
+		model = *body.Model
+	}
+	if (body.Provider != nil || body.Model != nil) && !apiCheckModel(w, r, provider, model) {
+		return
+	}
+
+	if body.Title != nil {
+		if err := db.RenameConversation(user.ID, conv.id, title); err != nil {
+			logger.Println("Error renaming conversation:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error renaming conversation")
+			return
+		}
+		conv.title = title
+	}
+
+	if body.Provider != nil || body.Model != nil {
+		if err := db.SetConversationModel(user.ID, conv.id, provider, model); err != nil {
+			logger.Println("Error setting conversation model:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error updating conversation")
+			return
+		}
+		conv.provider, conv.model = provider, model
+	}
+
+	if body.Params != nil {
+		if err := db.SetConversationParams(user.ID, conv.id, *body.Params); err != nil {
+			logger.Println("Error setting conversation parameters:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error updating conversation")
+			return
+		}
+		conv.params = *body.Params
+	}
+
+	if body.Persona != nil {
+		if err := db.SetConversationPersona(user.ID, conv.id, *body.Persona); err != nil {
+			logger.Println("Error setting conversation persona:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error updating conversation")
+			return
+		}
+		conv.persona = *body.Persona
+	}
+
+	writeJSON(w, http.StatusOK, apiConversation{
+		ID:       conv.id.Hex(),
+		Title:    conv.title,
+		Provider: conv.provider,
+		Model:    conv.model,
+		Params:   conv.params,
+		Persona:  conv.persona,
+	})
+}
+
+func apiDeleteConversation(w http.ResponseWriter, r *http.Request, user *database.User, id string) {
+	conv := apiLoadConversation(w, user, id)
+	if conv == nil {
+		return
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	if err := db.DeleteConversation(user.ID, conv.id); err != nil && err != database.ErrConversationNotFound {
+		logger.Println("Error deleting conversation:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error deleting conversation")
+		return
+	}
+	conversations.remove(user.ID, conv.id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListMessages(w http.ResponseWriter, r *http.Request, user *database.User, id string) {
+	conv := apiLoadConversation(w, user, id)
+	if conv == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiMessageList{ConversationID: conv.id.Hex(), Messages: conv.snapshot()})
+}
+
+func apiSendMessage(w http.ResponseWriter, r *http.Request, user *database.User, id string) {
+	var body apiMessageRequest
+	var tooLarge *http.MaxBytesError
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBytes)).Decode(&body); errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("Messages and their attachments are limited to %d MB", maxMessageBytes>>20))
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "Content is required")
+		return
+	}
+	if !apiCheckParams(w, &body.Params) {
+		return
+	}
+
+	conv := apiLoadConversation(w, user, id)
+	if conv == nil {
+		return
+	}
+	content, set := attachUploads(user.ID, conv, body)
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	resp, err := sendMessage(r.Context(), user, conv, content, llm.Options{Provider: body.Provider, Model: body.Model, Params: body.Params})
+	var exceeded *limitError
+	if errors.As(err, &exceeded) {
+		writeLimitError(w, exceeded)
+		return
+	} else if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiMessageResponse{
+		ConversationID: conv.id.Hex(),
+		Index:          len(conv.messages) - 1,
+		Message:        resp.Message,
+		Model:          resp.Model,
+		Provider:       resp.Provider,
+		Usage:          resp.Usage,
+		Attached:       set.Files,
+		Skipped:        set.Skipped,
+	})
+}
+
+// attachUploads returns the content of a message with its attachments
+// inlined, within the attachment budget of the model that will answer in
+// conv. It must be called without holding conv.mu.
+func attachUploads(userID primitive.ObjectID, conv *conversation, body apiMessageRequest) (string, *attach.Set) {
+	if len(body.Attachments) == 0 {
+		return body.Content, &attach.Set{}
+	}
+
+	t := llm.Target{Provider: body.Provider, Model: body.Model}
+	if t.Provider == "" && t.Model == "" {
+		_, name := conv.settings()
+		var p persona.Persona
+		if name != "" {
+			p, _ = findPersona(userID, name)
+		}
+		t = conv.target(p)
+	}
+	if t.Provider == "" {
+		t.Provider = llmClient.Provider()
+	}
+	if t.Model == "" {
+		t.Model = llmClient.ProviderModel(t.Provider)
+	}
+
+	set := attach.NewSet(attach.Budget(llmClient.ContextWindow(t.Provider, t.Model)))
+	for _, a := range body.Attachments {
+		set.AddData(a.Name, a.Data)
+	}
+	return set.Prompt(body.Content), set
+}
+
+// handleAPIModels lists the models of every configured backend:
+//
+//	GET /api/v1/models
+func handleAPIModels(w http.ResponseWriter, r *http.Request) {
+	if getUserFromSession(r) == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	result := apiModelList{Models: []apiModel{}}
+	for _, target := range modelCatalog(r.Context()) {
+		result.Models = append(result.Models, apiModel{
+			Provider:      target.Provider,
+			Model:         target.Model,
+			ContextWindow: llmClient.ContextWindow(target.Provider, target.Model),
+			Default:       target == defaultTarget(),
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func toAPIKey(k database.APIKey) apiKey {
+	result := apiKey{ID: k.ID.Hex(), Name: k.Name, Hint: k.Hint, CreatedAt: k.CreatedAt}
+	if !k.LastUsedAt.IsZero() {
+		result.LastUsedAt = &k.LastUsedAt
+	}
+	return result
+}
+
+// handleAPIKeys manages the API keys used by the OpenAI-compatible gateway:
+//
+//	GET    /api/v1/keys        list
+//	POST   /api/v1/keys        create; the response holds the key once
+//	DELETE /api/v1/keys/{id}   revoke
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/keys"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		keys, err := db.ListAPIKeys(user.ID)
+		if err != nil {
+			logger.Println("Error listing API keys:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error listing API keys")
+			return
+		}
+		result := []apiKey{}
+		for _, k := range keys {
+			result = append(result, toAPIKey(k))
+		}
+		writeJSON(w, http.StatusOK, map[string][]apiKey{"keys": result})
+
+	case id == "" && r.Method == http.MethodPost:
+		var body apiKeyRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+				return
+			}
+		}
+		key, stored, err := db.CreateAPIKey(user.ID, strings.TrimSpace(body.Name))
+		if err != nil {
+			logger.Println("Error creating API key:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error creating API key")
+			return
+		}
+		result := toAPIKey(*stored)
+		result.Key = key
+		writeJSON(w, http.StatusCreated, result)
+
+	case id != "" && r.Method == http.MethodDelete:
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, "not_found", "API key not found")
+			return
+		}
+		err = db.DeleteAPIKey(user.ID, objID)
+		if err == database.ErrAPIKeyNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "API key not found")
+			return
+		} else if err != nil {
+			logger.Println("Error deleting API key:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error deleting API key")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
+
+// handleAPIPersonas manages personas:
+//
+//	GET    /api/v1/personas          list shared and own personas
+//	POST   /api/v1/personas          create or replace an own persona
+//	GET    /api/v1/personas/{name}   fetch one
+//	DELETE /api/v1/personas/{name}   delete an own persona
+func handleAPIPersonas(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/personas"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		own, err := db.ListPersonas(user.ID)
+		if err != nil {
+			logger.Println("Error listing personas:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error listing personas")
+			return
+		}
+		result := []apiPersona{}
+		for _, p := range listPersonas(user.ID) {
+			source := "server"
+			for _, o := range own {
+				if o.Name == p.Name {
+					source = "user"
+				}
+			}
+			result = append(result, apiPersona{Persona: p, Source: source})
+		}
+		writeJSON(w, http.StatusOK, map[string][]apiPersona{"personas": result})
+
+	case name == "" && r.Method == http.MethodPost:
+		var body persona.Persona
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if err := body.Validate(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		if !apiCheckModel(w, r, body.Provider, body.Model) {
+			return
+		}
+		saved, err := db.SavePersona(user.ID, body)
+		if err != nil {
+			logger.Println("Error saving persona:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error saving persona")
+			return
+		}
+		writeJSON(w, http.StatusOK, apiPersona{Persona: saved.Persona, Source: "user"})
+
+	case name != "" && r.Method == http.MethodGet:
+		p, err := findPersona(user.ID, name)
+		if errors.Is(err, persona.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Persona not found")
+			return
+		} else if err != nil {
+			logger.Println("Error loading persona:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading persona")
+			return
+		}
+		source := "server"
+		if _, err := db.GetPersona(user.ID, name); err == nil {
+			source = "user"
+		}
+		writeJSON(w, http.StatusOK, apiPersona{Persona: p, Source: source})
+
+	case name != "" && r.Method == http.MethodDelete:
+		err := db.DeletePersona(user.ID, name)
+		if err == database.ErrPersonaNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Persona not found")
+			return
+		} else if err != nil {
+			logger.Println("Error deleting persona:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error deleting persona")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
@@ -0,0 +1,131 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"askgo/llm/llmtest"
+	"askgo/persona"
+)
+
+// sendJSON sends body to path as the user of cookie.
+func sendJSON(cookie *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	newHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPICreateConversation(t *testing.T) {
+	mem, _ := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	mem.SavePersona(user.ID, persona.Persona{Name: "pirate", System: "Talk like a pirate."})
+
+	rec := sendJSON(cookie, http.MethodPost, apiPrefix, `{
+		"title": " Voyage ",
+		"provider": "`+llmtest.FakeName+`",
+		"model": "`+llmtest.FakeModel+`",
+		"params": {"temperature": 0.3},
+		"persona": "pirate"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	var created apiConversation
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Title != "Voyage" || created.Model != llmtest.FakeModel || created.Persona != "pirate" || created.Params.Temperature == nil {
+		t.Errorf("response = %+v, want every setting", created)
+	}
+
+	stored := mem.conversations[0]
+	if stored.ID.Hex() != created.ID || stored.Provider != llmtest.FakeName || stored.Persona != "pirate" || *stored.Params.Temperature != 0.3 {
+		t.Errorf("stored %+v, want every setting", stored)
+	}
+	// One write stores the whole conversation
+	for _, method := range []string{"CreateConversation", "SetConversationModel", "SetConversationParams", "SetConversationPersona"} {
+		if mem.calls[method] != 0 {
+			t.Errorf("created the conversation with %s", method)
+		}
+	}
+	if mem.calls["InsertConversation"] != 1 {
+		t.Errorf("InsertConversation called %d times, want once", mem.calls["InsertConversation"])
+	}
+}
+
+func TestAPICreateConversationErrors(t *testing.T) {
+	mem, _ := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	logs := captureLog(t)
+
+	tests := []struct {
+		name string
+		body string
+		fail string
+		want int
+	}{
+		{"unknown provider", `{"provider":"nope"}`, "", http.StatusBadRequest},
+		{"bad parameter", `{"params":{"top_p":3}}`, "", http.StatusBadRequest},
+		{"unknown persona", `{"persona":"nobody"}`, "", http.StatusBadRequest},
+		{"store fails", `{"title":"lost","params":{"seed":1}}`, "InsertConversation", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fail != "" {
+				mem.fail[tt.fail] = errStore
+				defer delete(mem.fail, tt.fail)
+			}
+			if rec := sendJSON(cookie, http.MethodPost, apiPrefix, tt.body); rec.Code != tt.want {
+				t.Errorf("POST = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+	if len(mem.conversations) != 0 {
+		t.Errorf("failed requests left %+v", mem.conversations)
+	}
+	if !strings.Contains(logs.String(), "Error creating conversation: "+errStore.Error()) {
+		t.Errorf("log = %q, want the store error", logs.String())
+	}
+}
+
+func TestAPIUpdateConversationValidatesFirst(t *testing.T) {
+	mem, _ := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	conv, _ := mem.CreateConversation(user.ID, "Original")
+	path := apiPrefix + "/" + conv.ID.Hex()
+
+	for _, body := range []string{
+		`{"title":"Renamed","model":"bogus"}`,
+		`{"title":"Renamed","provider":"nope"}`,
+	} {
+		if rec := sendJSON(cookie, http.MethodPatch, path, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PATCH %s = %d %s, want 400", body, rec.Code, rec.Body)
+		}
+	}
+	if stored := mem.conversations[0]; stored.Title != "Original" || stored.Model != "" {
+		t.Errorf("rejected updates were applied: %+v", stored)
+	}
+	for _, method := range []string{"RenameConversation", "SetConversationModel"} {
+		if mem.calls[method] != 0 {
+			t.Errorf("rejected update called %s", method)
+		}
+	}
+
+	rec := sendJSON(cookie, http.MethodPatch, path, `{"title":"Renamed","model":"`+llmtest.FakeModel+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid PATCH = %d %s", rec.Code, rec.Body)
+	}
+	if stored := mem.conversations[0]; stored.Title != "Renamed" || stored.Model != llmtest.FakeModel {
+		t.Errorf("stored %+v, want the new title and model", stored)
+	}
+}
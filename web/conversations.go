@@ -0,0 +1,325 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/persona"
+)
+
+type conversationKey struct {
+	userID         primitive.ObjectID
+	conversationID primitive.ObjectID
+}
+
+// conversation is the in-memory state of one user's chat. mu is held for a
+// whole turn so concurrent requests to the same conversation are applied in
+// order instead of interleaving.
+type conversation struct {
+	mu    sync.Mutex
+	id    primitive.ObjectID
+	title string
+	// provider and model are empty for the server default.
+	provider string
+	model    string
+	params   llm.Params
+	persona  string
+	messages []llm.Message
+	// summary stands in for the oldest messages in requests.
+	summary llm.Summary
+}
+
+// Limits of the conversation cache. Conversations are saved after every
+// turn, so dropping one only costs a reload.
+const (
+	// conversationIdleTimeout is how long an unused conversation stays
+	// cached, e.g. after its session expired without a logout.
+	conversationIdleTimeout = 30 * time.Minute
+	// maxCachedConversations caps the cache; the least recently used
+	// conversations are dropped beyond it.
+	maxCachedConversations = 10000
+)
+
+// cachedConversation is a cache entry and when it was last used.
+type cachedConversation struct {
+	conv     *conversation
+	lastUsed time.Time
+}
+
+// conversationLoad is a database load in progress. Requests for the same
+// conversation wait for it instead of loading it again.
+type conversationLoad struct {
+	done chan struct{}
+	conv *conversation
+	err  error
+}
+
+// conversationStore caches conversations per user, loading them from the
+// database the first time they are used. mu only guards the maps; loads
+// run without it so a slow query holds up no other conversation.
+type conversationStore struct {
+	mu            sync.Mutex
+	conversations map[conversationKey]*cachedConversation
+	loads         map[conversationKey]*conversationLoad
+	idleTimeout   time.Duration
+	maxSize       int
+	now           func() time.Time
+	// pruned is when idle conversations were last looked for
+	pruned time.Time
+}
+
+func newConversationStore() *conversationStore {
+	return &conversationStore{
+		conversations: make(map[conversationKey]*cachedConversation),
+		loads:         make(map[conversationKey]*conversationLoad),
+		idleTimeout:   conversationIdleTimeout,
+		maxSize:       maxCachedConversations,
+		now:           time.Now,
+	}
+}
+
+// get returns conversation id of userID, loading its history on demand. It
+// returns database.ErrConversationNotFound for conversations the user does
+// not own.
+func (s *conversationStore) get(userID, id primitive.ObjectID) (*conversation, error) {
+	key := conversationKey{userID: userID, conversationID: id}
+
+	s.mu.Lock()
+	if cached, ok := s.conversations[key]; ok {
+		cached.lastUsed = s.now()
+		s.mu.Unlock()
+		return cached.conv, nil
+	}
+	if load, ok := s.loads[key]; ok {
+		s.mu.Unlock()
+		<-load.done
+		return load.conv, load.err
+	}
+	load := &conversationLoad{done: make(chan struct{})}
+	s.loads[key] = load
+	s.mu.Unlock()
+
+	load.conv, load.err = loadStoredConversation(userID, id)
+
+	s.mu.Lock()
+	// A conversation removed while it loaded is not cached
+	if s.loads[key] == load {
+		delete(s.loads, key)
+		if load.err == nil {
+			s.conversations[key] = &cachedConversation{conv: load.conv, lastUsed: s.now()}
+			s.prune()
+		}
+	}
+	s.mu.Unlock()
+	close(load.done)
+	return load.conv, load.err
+}
+
+// prune drops idle conversations and, beyond maxSize, the least recently
+// used ones. Conversations in the middle of a turn are kept so that no
+// second copy of them gets loaded. s.mu must be held.
+func (s *conversationStore) prune() {
+	// Looking at every entry once a minute is plenty for a 30 minute timeout
+	if now := s.now(); now.Sub(s.pruned) >= time.Minute {
+		s.pruned = now
+		idleSince := now.Add(-s.idleTimeout)
+		for key, cached := range s.conversations {
+			if cached.lastUsed.Before(idleSince) && cached.idle() {
+				delete(s.conversations, key)
+			}
+		}
+	}
+	for len(s.conversations) > s.maxSize {
+		var oldest conversationKey
+		var oldestUsed time.Time
+		for key, cached := range s.conversations {
+			if cached.idle() && (oldestUsed.IsZero() || cached.lastUsed.Before(oldestUsed)) {
+				oldest, oldestUsed = key, cached.lastUsed
+			}
+		}
+		if oldestUsed.IsZero() {
+			return
+		}
+		delete(s.conversations, oldest)
+	}
+}
+
+// idle reports whether no turn is running in the conversation.
+func (c *cachedConversation) idle() bool {
+	if !c.conv.mu.TryLock() {
+		return false
+	}
+	c.conv.mu.Unlock()
+	return true
+}
+
+// loadStoredConversation reads conversation id of userID from the database.
+func loadStoredConversation(userID, id primitive.ObjectID) (*conversation, error) {
+	stored, err := db.GetConversation(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation{
+		id:       id,
+		title:    stored.Title,
+		provider: stored.Provider,
+		model:    stored.Model,
+		params:   stored.Params,
+		persona:  stored.Persona,
+		messages: stored.Messages,
+		summary:  stored.Summary,
+	}, nil
+}
+
+// target returns the backend answering in the conversation, which uses p
+// as its persona.
+func (c *conversation) target(p persona.Persona) llm.Target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := llm.Target{Provider: c.provider, Model: c.model}
+	if t.Provider == "" && t.Model == "" {
+		t = llm.Target{Provider: p.Provider, Model: p.Model}
+	}
+	if t.Provider == "" {
+		t.Provider = llmClient.Provider()
+	}
+	if t.Model == "" {
+		t.Model = llmClient.ProviderModel(t.Provider)
+	}
+	return t
+}
+
+// settings returns the conversation's sampling parameters and persona.
+func (c *conversation) settings() (llm.Params, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.params, c.persona
+}
+
+// snapshot returns a copy of the conversation's messages.
+func (c *conversation) snapshot() []llm.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]llm.Message(nil), c.messages...)
+}
+
+// remove drops conversation id of userID from the cache once it has been
+// deleted from the database. The caller holds its mu, so no turn is left
+// running in it, and a later load finds nothing to make a second copy of.
+func (s *conversationStore) remove(userID, id primitive.ObjectID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := conversationKey{userID: userID, conversationID: id}
+	delete(s.conversations, key)
+	delete(s.loads, key)
+}
+
+// evict drops the cached conversations of userID. Like prune it keeps
+// those in the middle of a turn, and loads in progress, so that no second
+// copy of them gets loaded; they expire once idle.
+func (s *conversationStore) evict(userID primitive.ObjectID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, cached := range s.conversations {
+		if key.userID == userID && cached.idle() {
+			delete(s.conversations, key)
+		}
+	}
+}
+
+// recordUsage adds the tokens resp took to the daily totals of userID.
+func recordUsage(userID primitive.ObjectID, resp *llm.Response) {
+	if err := db.RecordUsage(userID, resp.Provider, resp.Model, resp.Usage); err != nil {
+		logger.Println("Error recording usage:", err)
+	}
+}
+
+// sendMessage runs one chat turn in conv: the reply is streamed to the
+// user's WebSocket clients and the updated history is saved. The
+// conversation's model and parameters are used unless opts overrides them,
+// then those of its persona. Once the history grows close to the model's
+// context window its oldest turns are folded into the conversation's
+// summary, which replaces them in this and later requests. The turn is
+// counted against the user's limits first; a refusal is a *limitError. The
+// caller holds conv.mu.
+func sendMessage(ctx context.Context, user *database.User, conv *conversation, content string, opts llm.Options) (*llm.Response, error) {
+	userID := user.ID
+	conversationID := conv.id.Hex()
+	if opts.Provider == "" && opts.Model == "" {
+		opts.Provider, opts.Model = conv.provider, conv.model
+	}
+	opts.Params = conv.params.Merge(opts.Params)
+
+	var p persona.Persona
+	if conv.persona != "" {
+		found, err := findPersona(userID, conv.persona)
+		if err != nil {
+			logger.Println("Error loading persona:", err)
+		}
+		p = found
+	}
+	opts = p.Options(opts)
+
+	messages := append(conv.messages, llm.Message{Role: llm.RoleUser, Content: content})
+	res, err := reserve(user, p.Messages(conv.summary.Apply(messages)), opts, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	used := 0
+	defer func() { res.settle(used) }()
+
+	// Name untitled conversations after their first message
+	if conv.title == "" {
+		conv.title = conversationTitle(content)
+		if err := db.RenameConversation(userID, conv.id, conv.title); err != nil {
+			logger.Println("Error naming conversation:", err)
+		}
+	}
+
+	// A failed summary is not fatal: the client still drops what does not
+	// fit, and the next turn tries again
+	summary, summaryResp, err := llmClient.Compact(ctx, conv.summary, messages, opts)
+	if err != nil {
+		logger.Println("Error summarizing conversation:", err)
+	} else if summaryResp != nil {
+		recordUsage(userID, summaryResp)
+		used += usedTokens(summaryResp, 0)
+		conv.summary = summary
+		if err := db.SaveConversationSummary(userID, conv.id, summary); err != nil {
+			logger.Println("Error saving conversation summary:", err)
+		}
+	}
+
+	// Stream the reply to the user's WebSocket clients as it arrives
+	resp, err := llmClient.ChatStream(ctx, p.Messages(conv.summary.Apply(messages)), opts, func(delta string) error {
+		broadcastEvent(userID, wsEvent{Type: "delta", ConversationID: conversationID, Content: delta})
+		return nil
+	})
+	if err != nil {
+		logger.Println("Error sending request:", err)
+		broadcastEvent(userID, wsEvent{Type: "error", ConversationID: conversationID, Content: llm.UserMessage(err)})
+		return nil, err
+	}
+
+	recordUsage(userID, resp)
+	used += usedTokens(resp, res.tokens)
+
+	// Format the response for code blocks
+	resp.Message.Content = formatAIResponse(resp.Message.Content)
+	conv.messages = append(messages, resp.Message)
+
+	// Save chat history
+	if err := db.SaveConversationMessages(userID, conv.id, conv.messages); err != nil {
+		logger.Println("Error saving chat history:", err)
+	}
+
+	// Send the complete response to the user's WebSocket clients
+	broadcastEvent(userID, wsEvent{Type: "done", ConversationID: conversationID, Content: resp.Message.Content})
+	return resp, nil
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"askgo/database"
+)
+
+// slowStore holds up loads of one conversation until release is closed.
+type slowStore struct {
+	*memStore
+	slow    primitive.ObjectID
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) GetConversation(userID, id primitive.ObjectID) (*database.Conversation, error) {
+	if id == s.slow {
+		s.started <- struct{}{}
+		<-s.release
+	}
+	return s.memStore.GetConversation(userID, id)
+}
+
+// testClock is a settable time source for the conversation store.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newTestStore returns an empty conversation store on clock and a user
+// with n conversations.
+func newTestStore(t *testing.T, clock *testClock, n int) (*conversationStore, *database.User, []primitive.ObjectID) {
+	t.Helper()
+	mem, _ := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	var ids []primitive.ObjectID
+	for i := 0; i < n; i++ {
+		conv, _ := mem.CreateConversation(user.ID, "")
+		ids = append(ids, conv.ID)
+	}
+	s := newConversationStore()
+	s.now = clock.Now
+	return s, user, ids
+}
+
+func (s *conversationStore) cached(userID, id primitive.ObjectID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.conversations[conversationKey{userID: userID, conversationID: id}]
+	return ok
+}
+
+func TestConversationStoreLoadsOutsideLock(t *testing.T) {
+	s, user, ids := newTestStore(t, &testClock{now: time.Now()}, 2)
+	slow := &slowStore{memStore: db.(*memStore), slow: ids[0], started: make(chan struct{}), release: make(chan struct{})}
+	db = slow
+
+	var wg sync.WaitGroup
+	results := make([]*conversation, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = s.get(user.ID, ids[0])
+		}(i)
+	}
+	<-slow.started
+
+	// Another conversation loads while the first is stuck
+	done := make(chan error)
+	go func() {
+		_, err := s.get(user.ID, ids[1])
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow load blocked other conversations")
+	}
+
+	close(slow.release)
+	wg.Wait()
+	if results[0] == nil || results[0] != results[1] {
+		t.Errorf("concurrent gets returned %p and %p, want one shared conversation", results[0], results[1])
+	}
+	if n := slow.calls["GetConversation"]; n != 2 {
+		t.Errorf("GetConversation called %d times, want once per conversation", n)
+	}
+}
+
+func TestConversationStoreRemoveDuringLoad(t *testing.T) {
+	s, user, ids := newTestStore(t, &testClock{now: time.Now()}, 1)
+	slow := &slowStore{memStore: db.(*memStore), slow: ids[0], started: make(chan struct{}), release: make(chan struct{})}
+	db = slow
+
+	done := make(chan struct{})
+	go func() {
+		s.get(user.ID, ids[0])
+		close(done)
+	}()
+	<-slow.started
+	s.remove(user.ID, ids[0])
+	close(slow.release)
+	<-done
+
+	if s.cached(user.ID, ids[0]) {
+		t.Error("a conversation removed while loading was cached")
+	}
+}
+
+func TestConversationStoreEvictsIdle(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	s, user, ids := newTestStore(t, clock, 3)
+
+	s.get(user.ID, ids[0])
+	busy, _ := s.get(user.ID, ids[1])
+	busy.mu.Lock()
+	clock.now = clock.now.Add(conversationIdleTimeout + time.Minute)
+	s.get(user.ID, ids[2])
+	busy.mu.Unlock()
+
+	if s.cached(user.ID, ids[0]) {
+		t.Error("idle conversation stayed cached")
+	}
+	if !s.cached(user.ID, ids[1]) {
+		t.Error("conversation in the middle of a turn was dropped")
+	}
+	if !s.cached(user.ID, ids[2]) {
+		t.Error("new conversation was not cached")
+	}
+
+	// Dropped conversations load again
+	before := db.(*memStore).calls["GetConversation"]
+	if _, err := s.get(user.ID, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if db.(*memStore).calls["GetConversation"] != before+1 {
+		t.Error("evicted conversation was not reloaded")
+	}
+}
+
+func TestConversationStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	s, user, ids := newTestStore(t, clock, 3)
+	s.maxSize = 2
+
+	for _, id := range ids[:2] {
+		s.get(user.ID, id)
+		clock.now = clock.now.Add(time.Second)
+	}
+	// Using the first again makes the second the least recently used
+	s.get(user.ID, ids[0])
+	clock.now = clock.now.Add(time.Second)
+	s.get(user.ID, ids[2])
+
+	want := []bool{true, false, true}
+	for i, id := range ids {
+		if got := s.cached(user.ID, id); got != want[i] {
+			t.Errorf("conversation %d cached = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestConversationStoreEvictUser(t *testing.T) {
+	s, user, ids := newTestStore(t, &testClock{now: time.Now()}, 3)
+	for _, id := range ids[:2] {
+		s.get(user.ID, id)
+	}
+	busy, _ := s.get(user.ID, ids[1])
+	busy.mu.Lock()
+
+	// A load in progress finishes into the cache
+	slow := &slowStore{memStore: db.(*memStore), slow: ids[2], started: make(chan struct{}), release: make(chan struct{})}
+	db = slow
+	loaded := make(chan *conversation)
+	go func() {
+		conv, _ := s.get(user.ID, ids[2])
+		loaded <- conv
+	}()
+	<-slow.started
+
+	s.evict(user.ID)
+	close(slow.release)
+	first := <-loaded
+
+	if s.cached(user.ID, ids[0]) {
+		t.Error("idle conversation stayed cached after logout")
+	}
+	// The running turn and the loaded copy stay the only ones
+	if again, _ := s.get(user.ID, ids[1]); again != busy {
+		t.Error("conversation in the middle of a turn was loaded a second time")
+	}
+	if again, _ := s.get(user.ID, ids[2]); again != first {
+		t.Error("conversation loading during logout was loaded a second time")
+	}
+	busy.mu.Unlock()
+
+	s.evict(user.ID)
+	if s.cached(user.ID, ids[1]) {
+		t.Error("conversation stayed cached after its turn ended")
+	}
+}
+
+func TestConversationStoreRemoveDeleted(t *testing.T) {
+	s, user, ids := newTestStore(t, &testClock{now: time.Now()}, 1)
+	conv, _ := s.get(user.ID, ids[0])
+
+	// As the delete handlers do
+	conv.mu.Lock()
+	db.DeleteConversation(user.ID, ids[0])
+	s.remove(user.ID, ids[0])
+	conv.mu.Unlock()
+
+	if _, err := s.get(user.ID, ids[0]); err != database.ErrConversationNotFound {
+		t.Errorf("get after delete = %v, want ErrConversationNotFound", err)
+	}
+}
@@ -0,0 +1,276 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"askgo/database"
+	"askgo/llm"
+)
+
+// DefaultLimits apply to every user whose limits an admin has not
+// overridden, unless Config.Limits is set.
+var DefaultLimits = database.Limits{RequestsPerMinute: 20, TokensPerDay: 200000}
+
+var (
+	serverLimits = DefaultLimits
+	// admins holds the emails of the users allowed to override limits
+	admins = make(map[string]bool)
+)
+
+// defaultReplyTokens is reserved for the reply of a request that does not
+// set max_tokens.
+const defaultReplyTokens = 1024
+
+// limitError is a request refused for exceeding a limit, or because the
+// limits could not be checked.
+type limitError struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string { return e.message }
+
+// errLimitsUnavailable refuses requests while the counters cannot be
+// read, so a database outage does not lift the limits.
+var errLimitsUnavailable = &limitError{
+	status:  http.StatusServiceUnavailable,
+	code:    "limits_unavailable",
+	message: "Usage limits cannot be checked right now; try again shortly",
+}
+
+// userLimits returns the limits that apply to user.
+func userLimits(user *database.User) database.Limits {
+	if user.Limits != nil {
+		return *user.Limits
+	}
+	return serverLimits
+}
+
+// reservation holds the tokens set aside for a request until the model
+// calls it makes are done.
+type reservation struct {
+	userID primitive.ObjectID
+	day    string
+	tokens int
+}
+
+// reserve counts a request of user at now that sends messages with opts
+// and sets aside the tokens it may use: the prompt, at most the model's
+// context window, plus max_tokens or defaultReplyTokens. The daily check
+// and the reservation are one atomic update, so concurrent requests
+// cannot overrun the limit together. It returns a *limitError when the
+// request must be refused; the caller settles the reservation once the
+// actual usage is known.
+func reserve(user *database.User, messages []llm.Message, opts llm.Options, now time.Time) (*reservation, error) {
+	limits := userLimits(user)
+
+	if limits.RequestsPerMinute > 0 {
+		window := now.Truncate(time.Minute)
+		count, err := db.CountRequest(user.ID, window)
+		if err != nil {
+			logger.Println("Error counting request:", err)
+			return nil, errLimitsUnavailable
+		}
+		if count > limits.RequestsPerMinute {
+			return nil, &limitError{
+				status:     http.StatusTooManyRequests,
+				code:       "rate_limited",
+				message:    fmt.Sprintf("Limit of %d requests per minute reached; try again shortly", limits.RequestsPerMinute),
+				retryAfter: window.Add(time.Minute).Sub(now),
+			}
+		}
+	}
+
+	t := llm.Target{Provider: opts.Provider, Model: opts.Model}
+	if t.Provider == "" {
+		t.Provider = llmClient.Provider()
+	}
+	if t.Model == "" {
+		t.Model = llmClient.ProviderModel(t.Provider)
+	}
+	tokens := min(llm.CountMessages(messages), llmClient.ContextWindow(t.Provider, t.Model))
+	if opts.Params.MaxTokens != nil {
+		tokens += *opts.Params.MaxTokens
+	} else {
+		tokens += defaultReplyTokens
+	}
+	if limits.TokensPerDay > 0 && tokens > limits.TokensPerDay {
+		return nil, &limitError{
+			status:  http.StatusTooManyRequests,
+			code:    "quota_exceeded",
+			message: fmt.Sprintf("This request may use up to %d tokens, more than the daily limit of %d", tokens, limits.TokensPerDay),
+		}
+	}
+
+	day := now.Format(database.DayFormat)
+	_, ok, err := db.ReserveTokens(user.ID, day, tokens, limits.TokensPerDay)
+	if err != nil {
+		logger.Println("Error reserving tokens:", err)
+		return nil, errLimitsUnavailable
+	}
+	if !ok {
+		tomorrow := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return nil, &limitError{
+			status:     http.StatusTooManyRequests,
+			code:       "quota_exceeded",
+			message:    fmt.Sprintf("Daily limit of %d tokens reached; it resets at midnight UTC", limits.TokensPerDay),
+			retryAfter: tomorrow.Sub(now),
+		}
+	}
+	return &reservation{userID: user.ID, day: day, tokens: tokens}, nil
+}
+
+// settle replaces the reserved tokens with used, the tokens the request
+// actually consumed.
+func (r *reservation) settle(used int) {
+	if err := db.SettleTokens(r.userID, r.day, used-r.tokens); err != nil {
+		logger.Println("Error settling tokens:", err)
+	}
+}
+
+// usedTokens is what resp is charged against the daily limit: its
+// reported usage, or estimate for backends that report none.
+func usedTokens(resp *llm.Response, estimate int) int {
+	if used := resp.Usage.PromptTokens + resp.Usage.CompletionTokens; used > 0 {
+		return used
+	}
+	return estimate
+}
+
+// writeLimitError answers a request refused by reserve.
+func writeLimitError(w http.ResponseWriter, exceeded *limitError) {
+	setRetryAfter(w, exceeded)
+	writeAPIError(w, exceeded.status, exceeded.code, exceeded.message)
+}
+
+func setRetryAfter(w http.ResponseWriter, exceeded *limitError) {
+	if exceeded.retryAfter > 0 {
+		seconds := int((exceeded.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+}
+
+type apiLimits struct {
+	Email  string          `json:"email,omitempty"`
+	Limits database.Limits `json:"limits"`
+	// Override is set when an admin replaced the server defaults.
+	Override    bool `json:"override"`
+	TokensToday int  `json:"tokens_today"`
+}
+
+func toAPILimits(user *database.User) apiLimits {
+	result := apiLimits{Email: user.Email, Limits: userLimits(user), Override: user.Limits != nil}
+	used, err := db.TokensUsed(user.ID, time.Now().UTC().Format(database.DayFormat))
+	if err != nil {
+		logger.Println("Error reading token usage:", err)
+	}
+	result.TokensToday = used
+	return result
+}
+
+// handleAPILimits reports the limits of the signed-in user and what is
+// left of today's tokens:
+//
+//	GET /api/v1/limits
+func handleAPILimits(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPILimits(user))
+}
+
+// handleAPIAdminLimits lets admins override the limits of single users:
+//
+//	GET    /api/v1/admin/limits           users with overridden limits
+//	GET    /api/v1/admin/limits/{email}   a user's limits
+//	PUT    /api/v1/admin/limits/{email}   override them; zero is unlimited
+//	DELETE /api/v1/admin/limits/{email}   restore the server defaults
+func handleAPIAdminLimits(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+	if !admins[strings.ToLower(user.Email)] {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Admins only")
+		return
+	}
+
+	email := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/limits"), "/")
+	if email == "" {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+		users, err := db.ListLimitedUsers()
+		if err != nil {
+			logger.Println("Error listing limits:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error listing limits")
+			return
+		}
+		result := []apiLimits{}
+		for i := range users {
+			result = append(result, apiLimits{Email: users[i].Email, Limits: *users[i].Limits, Override: true})
+		}
+		writeJSON(w, http.StatusOK, map[string][]apiLimits{"users": result})
+		return
+	}
+
+	target, err := db.GetUserByEmail(email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "User not found")
+		return
+	} else if err != nil {
+		logger.Println("Error loading user:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading user")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body database.Limits
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Invalid JSON body")
+			return
+		}
+		if body.RequestsPerMinute < 0 || body.TokensPerDay < 0 {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Limits must not be negative")
+			return
+		}
+		if err := db.SetUserLimits(target.ID, &body); err != nil {
+			logger.Println("Error setting limits:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error setting limits")
+			return
+		}
+		target.Limits = &body
+	case http.MethodDelete:
+		if err := db.SetUserLimits(target.ID, nil); err != nil {
+			logger.Println("Error resetting limits:", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error resetting limits")
+			return
+		}
+		target.Limits = nil
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPILimits(target))
+}
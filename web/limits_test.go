@@ -0,0 +1,353 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/llm/llmtest"
+)
+
+// bigReply uses 1000 tokens, so a second turn of defaultReplyTokens does
+// not fit in a daily limit of 1500.
+var bigReply = llmtest.Reply{Content: "Long answer", Usage: llm.Usage{PromptTokens: 900, CompletionTokens: 100, TotalTokens: 1000}}
+
+func today() string {
+	return time.Now().UTC().Format(database.DayFormat)
+}
+
+func TestReserveRequestsPerMinute(t *testing.T) {
+	mem, _ := newTestServer(t)
+	serverLimits = database.Limits{RequestsPerMinute: 2}
+	user := mem.addUser("ada@example.com")
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+	now := time.Date(2026, 10, 18, 12, 30, 45, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, err := reserve(user, messages, llm.Options{}, now); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	_, err := reserve(user, messages, llm.Options{}, now)
+	exceeded, ok := err.(*limitError)
+	if !ok || exceeded.status != http.StatusTooManyRequests || exceeded.code != "rate_limited" {
+		t.Fatalf("third request = %v, want rate_limited", err)
+	}
+	if exceeded.retryAfter != 15*time.Second {
+		t.Errorf("retry after %v, want the rest of the minute", exceeded.retryAfter)
+	}
+	if _, err := reserve(user, messages, llm.Options{}, now.Add(15*time.Second)); err != nil {
+		t.Errorf("request in the next minute: %v", err)
+	}
+}
+
+func TestReserveTokens(t *testing.T) {
+	mem, _ := newTestServer(t)
+	serverLimits = database.Limits{TokensPerDay: 3000}
+	user := mem.addUser("ada@example.com")
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+	now := time.Date(2026, 10, 18, 18, 0, 0, 0, time.UTC)
+	day := now.Format(database.DayFormat)
+
+	// max_tokens is reserved for the reply, defaultReplyTokens without it
+	maxTokens := 100
+	res, err := reserve(user, messages, llm.Options{Params: llm.Params{MaxTokens: &maxTokens}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := llm.CountMessages(messages) + maxTokens; res.tokens != want || mem.tokens[user.ID.Hex()+day] != want {
+		t.Errorf("reserved %d, counter %d, want %d", res.tokens, mem.tokens[user.ID.Hex()+day], want)
+	}
+	res.settle(40)
+	if got := mem.tokens[user.ID.Hex()+day]; got != 40 {
+		t.Errorf("counter after settling = %d, want the actual 40", got)
+	}
+
+	// Prompts longer than the context window are truncated, not charged
+	long := []llm.Message{{Role: llm.RoleUser, Content: strings.Repeat("word ", 3*llm.DefaultContextWindow)}}
+	serverLimits.TokensPerDay = 0
+	res, err = reserve(user, long, llm.Options{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := llm.DefaultContextWindow + defaultReplyTokens; res.tokens != want {
+		t.Errorf("reserved %d for a long prompt, want %d", res.tokens, want)
+	}
+	res.settle(0)
+	serverLimits.TokensPerDay = 3000
+
+	for i := 0; i < 2; i++ {
+		if _, err := reserve(user, messages, llm.Options{}, now); err != nil {
+			t.Fatalf("reservation %d: %v", i+1, err)
+		}
+	}
+	_, err = reserve(user, messages, llm.Options{}, now)
+	exceeded, ok := err.(*limitError)
+	if !ok || exceeded.code != "quota_exceeded" || exceeded.retryAfter != 6*time.Hour {
+		t.Fatalf("over the limit = %v, want quota_exceeded until midnight", err)
+	}
+	if got := mem.tokens[user.ID.Hex()+day]; got > serverLimits.TokensPerDay {
+		t.Errorf("counter %d passed the limit", got)
+	}
+
+	// A request larger than the whole limit never fits
+	maxTokens = 5000
+	_, err = reserve(user, messages, llm.Options{Params: llm.Params{MaxTokens: &maxTokens}}, now.AddDate(0, 0, 1))
+	if exceeded, ok := err.(*limitError); !ok || exceeded.code != "quota_exceeded" || exceeded.retryAfter != 0 {
+		t.Errorf("request over the daily limit = %v, want quota_exceeded without a retry time", err)
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	mem, _ := newTestServer(t)
+	serverLimits = database.Limits{TokensPerDay: 5 * (defaultReplyTokens + 10)}
+	user := mem.addUser("ada@example.com")
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+	now := time.Now().UTC()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := reserve(user, messages, llm.Options{}, now); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 5 {
+		t.Errorf("%d concurrent requests were let through, want 5", reserved)
+	}
+	if got := mem.tokens[user.ID.Hex()+now.Format(database.DayFormat)]; got > serverLimits.TokensPerDay {
+		t.Errorf("counter %d passed the limit of %d", got, serverLimits.TokensPerDay)
+	}
+}
+
+func TestLimitsModelPaths(t *testing.T) {
+	mem, fake := newTestServer(t, bigReply)
+	serverLimits = database.Limits{TokensPerDay: 1500}
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	key, _, _ := mem.CreateAPIKey(user.ID, "test")
+	conv, _ := mem.CreateConversation(user.ID, "")
+	messages := apiPrefix + "/" + conv.ID.Hex() + "/messages"
+
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Hi"}`); rec.Code != http.StatusOK {
+		t.Fatalf("first message = %d %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name string
+		send func() *httptest.ResponseRecorder
+	}{
+		{"api", func() *httptest.ResponseRecorder {
+			return sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`)
+		}},
+		{"trailing slash", func() *httptest.ResponseRecorder {
+			return sendJSON(cookie, http.MethodPost, messages+"/", `{"content":"Again"}`)
+		}},
+		{"double slash", func() *httptest.ResponseRecorder {
+			// ServeMux redirects unclean paths, so this reaches the API's
+			// own routing only behind a proxy that forwards them as is
+			req := httptest.NewRequest(http.MethodPost, messages+"//", strings.NewReader(`{"content":"Again"}`))
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			handleAPIConversations(rec, req)
+			return rec
+		}},
+		{"form", func() *httptest.ResponseRecorder {
+			return postForm(cookie, "/chat", url.Values{"message": {"Again"}, "conversation_id": {conv.ID.Hex()}})
+		}},
+		{"openai", func() *httptest.ResponseRecorder {
+			return postOpenAI(key, `{"messages":[{"role":"user","content":"Again"}]}`)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tt.send()
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("POST over the daily limit = %d %s, want 429", rec.Code, rec.Body)
+			}
+			if rec.Header().Get("Retry-After") == "" {
+				t.Error("429 without Retry-After")
+			}
+		})
+	}
+	if n := len(fake.Requests()); n != 1 {
+		t.Errorf("the model was called %d times, want only for the first message", n)
+	}
+}
+
+func TestLimitsQuotaResponses(t *testing.T) {
+	mem, _ := newTestServer(t, bigReply)
+	serverLimits = database.Limits{TokensPerDay: 1500}
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	key, _, _ := mem.CreateAPIKey(user.ID, "test")
+	conv, _ := mem.CreateConversation(user.ID, "")
+	messages := apiPrefix + "/" + conv.ID.Hex() + "/messages"
+	sendJSON(cookie, http.MethodPost, messages, `{"content":"Hi"}`)
+
+	rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`)
+	var apiBody apiError
+	json.NewDecoder(rec.Body).Decode(&apiBody)
+	if rec.Code != http.StatusTooManyRequests || apiBody.Error.Code != "quota_exceeded" {
+		t.Errorf("API = %d %+v, want 429 quota_exceeded", rec.Code, apiBody)
+	}
+
+	rec = postOpenAI(key, `{"messages":[{"role":"user","content":"Again"}]}`)
+	var openAIBody openAIError
+	json.NewDecoder(rec.Body).Decode(&openAIBody)
+	if rec.Code != http.StatusTooManyRequests || openAIBody.Error.Type != "rate_limit_error" ||
+		openAIBody.Error.Code == nil || *openAIBody.Error.Code != "insufficient_quota" {
+		t.Errorf("gateway = %d %s, want 429 insufficient_quota", rec.Code, rec.Body)
+	}
+
+	rec = get(cookie, "/api/v1/limits")
+	var limits apiLimits
+	json.NewDecoder(rec.Body).Decode(&limits)
+	if limits.TokensToday != 1000 || limits.Limits.TokensPerDay != 1500 {
+		t.Errorf("limits = %+v, want 1000 of 1500 tokens used", limits)
+	}
+}
+
+func TestLimitsSettle(t *testing.T) {
+	mem, fake := newTestServer(t,
+		llmtest.Reply{Content: "Hi", Usage: llm.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}},
+		llmtest.Reply{Err: &llm.StatusError{StatusCode: http.StatusBadRequest, Kind: llm.ErrBadRequest}},
+		llmtest.Reply{Content: "No usage reported"},
+	)
+	serverLimits = database.Limits{TokensPerDay: 100000}
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	conv, _ := mem.CreateConversation(user.ID, "")
+	messages := apiPrefix + "/" + conv.ID.Hex() + "/messages"
+	counter := func() int { return mem.tokens[user.ID.Hex()+today()] }
+
+	sendJSON(cookie, http.MethodPost, messages, `{"content":"Hi"}`)
+	if got := counter(); got != 15 {
+		t.Errorf("counter = %d after a reply of 15 tokens", got)
+	}
+
+	// A failed call gives its reservation back
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`); rec.Code == http.StatusOK {
+		t.Fatal("backend failure was reported as success")
+	}
+	if got := counter(); got != 15 {
+		t.Errorf("counter = %d after a failed call, want it released", got)
+	}
+
+	// Without reported usage the estimate is kept
+	sendJSON(cookie, http.MethodPost, messages, `{"content":"Once more"}`)
+	if got := counter(); got <= 15+defaultReplyTokens {
+		t.Errorf("counter = %d, want the estimate charged", got)
+	}
+	if n := len(fake.Requests()); n != 3 {
+		t.Errorf("model called %d times, want 3", n)
+	}
+}
+
+func TestLimitsFailClosed(t *testing.T) {
+	for _, method := range []string{"CountRequest", "ReserveTokens"} {
+		t.Run(method, func(t *testing.T) {
+			mem, fake := newTestServer(t, llmtest.Reply{Content: "Hi"})
+			serverLimits = database.Limits{RequestsPerMinute: 20, TokensPerDay: 100000}
+			logs := captureLog(t)
+
+			user := mem.addUser("ada@example.com")
+			cookie := login(t, user)
+			conv, _ := mem.CreateConversation(user.ID, "")
+			mem.fail[method] = errStore
+
+			rec := sendJSON(cookie, http.MethodPost, apiPrefix+"/"+conv.ID.Hex()+"/messages", `{"content":"Hi"}`)
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("POST = %d %s, want 503", rec.Code, rec.Body)
+			}
+			if len(fake.Requests()) != 0 {
+				t.Error("the model was called without checking the limits")
+			}
+			if !strings.Contains(logs.String(), errStore.Error()) {
+				t.Errorf("log = %q, want the store error", logs.String())
+			}
+		})
+	}
+}
+
+func TestAdminLimits(t *testing.T) {
+	mem, fake := newTestServer(t, bigReply, llmtest.Reply{Content: "Unlimited"})
+	serverLimits = database.Limits{TokensPerDay: 1500}
+	admins["root@example.com"] = true
+	user := mem.addUser("ada@example.com")
+	admin := mem.addUser("root@example.com")
+	cookie, adminCookie := login(t, user), login(t, admin)
+	conv, _ := mem.CreateConversation(user.ID, "")
+	messages := apiPrefix + "/" + conv.ID.Hex() + "/messages"
+	sendJSON(cookie, http.MethodPost, messages, `{"content":"Hi"}`)
+
+	if rec := sendJSON(cookie, http.MethodPut, "/api/v1/admin/limits/ada@example.com", `{"tokens_per_day":0}`); rec.Code != http.StatusForbidden {
+		t.Errorf("override by a user = %d, want 403", rec.Code)
+	}
+	if rec := sendJSON(adminCookie, http.MethodPut, "/api/v1/admin/limits/ada@example.com", `{"tokens_per_day":-1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative limit = %d, want 400", rec.Code)
+	}
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the default limit = %d, want 429", rec.Code)
+	}
+
+	// Zero lifts the limit
+	rec := sendJSON(adminCookie, http.MethodPut, "/api/v1/admin/limits/ada@example.com", `{"requests_per_minute":5,"tokens_per_day":0}`)
+	var got apiLimits
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != http.StatusOK || !got.Override || got.Limits.RequestsPerMinute != 5 || got.TokensToday != 1000 {
+		t.Fatalf("PUT = %d %+v", rec.Code, got)
+	}
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`); rec.Code != http.StatusOK {
+		t.Errorf("with the override = %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	rec = sendJSON(adminCookie, http.MethodDelete, "/api/v1/admin/limits/ada@example.com", "")
+	got = apiLimits{}
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != http.StatusOK || got.Override || got.Limits != serverLimits {
+		t.Fatalf("DELETE = %d %+v, want the defaults", rec.Code, got)
+	}
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after the override was removed = %d, want 429", rec.Code)
+	}
+	if n := len(fake.Requests()); n != 2 {
+		t.Errorf("model called %d times, want 2", n)
+	}
+}
+
+func TestLimitsSurviveRestart(t *testing.T) {
+	mem, fake := newTestServer(t, bigReply)
+	serverLimits = database.Limits{TokensPerDay: 1500}
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	conv, _ := mem.CreateConversation(user.ID, "")
+	messages := apiPrefix + "/" + conv.ID.Hex() + "/messages"
+	sendJSON(cookie, http.MethodPost, messages, `{"content":"Hi"}`)
+
+	// A restarted server keeps nothing in memory but the database
+	conversations = newConversationStore()
+	llmClient = llmtest.NewClient(fake)
+
+	if rec := sendJSON(cookie, http.MethodPost, messages, `{"content":"Again"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after a restart = %d %s, want 429", rec.Code, rec.Body)
+	}
+	if n := len(fake.Requests()); n != 1 {
+		t.Errorf("model called %d times, want once", n)
+	}
+}
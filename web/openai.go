@@ -0,0 +1,378 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"askgo/database"
+	"askgo/llm"
+)
+
+// The OpenAI-compatible gateway lets existing OpenAI SDK tooling use askGPT
+// as its base URL. Callers authenticate with a per-user askGPT API key and
+// the upstream key never leaves the server.
+
+type openAIChatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []llm.Message `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature"`
+	TopP        *float64      `json:"top_p"`
+	MaxTokens   *int          `json:"max_tokens"`
+	Stop        openAIStop    `json:"stop"`
+	Seed        *int64        `json:"seed"`
+}
+
+// openAIStop accepts OpenAI's stop field as a single string or a list.
+type openAIStop []string
+
+func (s *openAIStop) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = nil
+		if one != "" {
+			*s = openAIStop{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *llm.Usage     `json:"usage,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int          `json:"index"`
+	Message      *llm.Message `json:"message,omitempty"`
+	Delta        *openAIDelta `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// openAIDelta is the part of the reply a stream chunk adds. The last chunk
+// carries an empty delta, {}.
+type openAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+type openAIError struct {
+	Error openAIErrorDetail `json:"error"`
+}
+
+type openAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Code    *string `json:"code"`
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, openAIError{Error: openAIErrorDetail{Message: message, Type: errType}})
+}
+
+// writeOpenAIUpstreamError reports a failed model call in OpenAI's format,
+// passing through the backend's own message and code when it sent one.
+func writeOpenAIUpstreamError(w http.ResponseWriter, err error) {
+	status, code := upstreamStatus(err)
+	detail := openAIErrorDetail{Message: llm.UserMessage(err), Type: code}
+	switch {
+	case errors.Is(err, llm.ErrRateLimited):
+		detail.Type = "rate_limit_error"
+	case status == http.StatusBadRequest:
+		detail.Type = "invalid_request_error"
+	}
+	if code != detail.Type {
+		detail.Code = &code
+	}
+
+	var statusErr *llm.StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.API != nil {
+			detail.Message = statusErr.API.Message
+		}
+		if statusErr.RequestID != "" {
+			w.Header().Set("X-Upstream-Request-Id", statusErr.RequestID)
+		}
+		if statusErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(statusErr.RetryAfter.Round(time.Second)/time.Second)))
+		}
+	}
+	writeJSON(w, status, openAIError{Error: detail})
+}
+
+// writeOpenAILimitError answers a request refused by reserve with the
+// codes OpenAI clients already handle.
+func writeOpenAILimitError(w http.ResponseWriter, exceeded *limitError) {
+	setRetryAfter(w, exceeded)
+	detail := openAIErrorDetail{Message: exceeded.message, Type: "server_error"}
+	if exceeded.status == http.StatusTooManyRequests {
+		code := "rate_limit_exceeded"
+		if exceeded.code == "quota_exceeded" {
+			code = "insufficient_quota"
+		}
+		detail.Type, detail.Code = "rate_limit_error", &code
+	}
+	writeJSON(w, exceeded.status, openAIError{Error: detail})
+}
+
+// getUserFromAPIKey authenticates the bearer token of r as an askGPT API
+// key. A missing or unknown key, or one whose user is gone, is
+// database.ErrAPIKeyNotFound.
+func getUserFromAPIKey(r *http.Request) (*database.User, error) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" || key == r.Header.Get("Authorization") {
+		return nil, database.ErrAPIKeyNotFound
+	}
+	user, err := db.AuthenticateAPIKey(key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, database.ErrAPIKeyNotFound
+	}
+	return user, err
+}
+
+// authenticateOpenAI returns the user of the API key of r, or answers 401
+// for a bad key and 500 when keys cannot be checked and returns nil.
+func authenticateOpenAI(w http.ResponseWriter, r *http.Request) *database.User {
+	user, err := getUserFromAPIKey(r)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "Invalid API key")
+		return nil
+	} else if err != nil {
+		logger.Println("Error authenticating API key:", err)
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Error checking the API key")
+		return nil
+	}
+	return user
+}
+
+func handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if authenticateOpenAI(w, r) == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+
+	// Completions go to the default backend, so list its models
+	models, err := llmClient.Models(r.Context(), "")
+	if err != nil {
+		logger.Println("Error listing models:", err)
+		models = []string{llmClient.Model()}
+	}
+	list := openAIModelList{Object: "list", Data: []openAIModel{}}
+	for _, model := range models {
+		list.Data = append(list.Data, openAIModel{ID: model, Object: "model", OwnedBy: llmClient.Provider()})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleOpenAIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	user := authenticateOpenAI(w, r)
+	if user == nil {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+
+	var body openAIChatRequest
+	var tooLarge *http.MaxBytesError
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBytes)).Decode(&body); errors.As(err, &tooLarge) {
+		writeOpenAIError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", fmt.Sprintf("Requests are limited to %d MB", maxMessageBytes>>20))
+		return
+	} else if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body")
+		return
+	}
+	if len(body.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	}
+
+	params := llm.Params{
+		Temperature: body.Temperature,
+		TopP:        body.TopP,
+		MaxTokens:   body.MaxTokens,
+		Stop:        body.Stop,
+		Seed:        body.Seed,
+	}
+	if err := params.Validate(); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	id := "chatcmpl-" + primitive.NewObjectID().Hex()
+	created := time.Now().Unix()
+	opts := llm.Options{Model: body.Model, Params: params}
+
+	res, err := reserve(user, body.Messages, opts, time.Now().UTC())
+	var exceeded *limitError
+	if errors.As(err, &exceeded) {
+		writeOpenAILimitError(w, exceeded)
+		return
+	}
+	used := 0
+	defer func() { res.settle(used) }()
+
+	var resp *llm.Response
+	if body.Stream {
+		resp, err = streamOpenAICompletion(w, r, id, created, body, opts)
+		if err != nil {
+			logger.Println("Error proxying completion:", err)
+			return
+		}
+	} else {
+		resp, err = llmClient.Chat(r.Context(), body.Messages, opts)
+		if err != nil {
+			logger.Println("Error proxying completion:", err)
+			writeOpenAIUpstreamError(w, err)
+			return
+		}
+		message := llm.Message{Role: resp.Message.Role, Content: resp.Message.Content}
+		finish := finishReason(resp)
+		writeJSON(w, http.StatusOK, openAIChatResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   resp.Model,
+			Choices: []openAIChoice{{Message: &message, FinishReason: &finish}},
+			Usage:   &resp.Usage,
+		})
+	}
+
+	recordUsage(user.ID, resp)
+	used = usedTokens(resp, res.tokens)
+	logExchange(user.ID, body.Messages, resp.Message)
+}
+
+// finishReason is the finish_reason reported for resp: "length" when the
+// answer hit max_tokens, the backend's own reason otherwise.
+func finishReason(resp *llm.Response) string {
+	if resp.FinishReason == "" {
+		return llm.FinishStop
+	}
+	return resp.FinishReason
+}
+
+// streamOpenAICompletion relays the reply as OpenAI "chat.completion.chunk"
+// server-sent events. Once the first event is written errors can no longer
+// change the status code, so they are only returned to the caller.
+func streamOpenAICompletion(w http.ResponseWriter, r *http.Request, id string, created int64, body openAIChatRequest, opts llm.Options) (*llm.Response, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Streaming unsupported")
+		return nil, fmt.Errorf("response writer does not support flushing")
+	}
+
+	model := body.Model
+	if model == "" {
+		model = llmClient.Model()
+	}
+	started := false
+	writeChunk := func(chunk openAIChatResponse) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			started = true
+		}
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	chunk := func(delta openAIDelta, finishReason *string) openAIChatResponse {
+		return openAIChatResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []openAIChoice{{Delta: &delta, FinishReason: finishReason}},
+		}
+	}
+
+	resp, err := llmClient.ChatStream(r.Context(), body.Messages, opts, func(delta string) error {
+		if !started {
+			if err := writeChunk(chunk(openAIDelta{Role: llm.RoleAssistant}, nil)); err != nil {
+				return err
+			}
+		}
+		return writeChunk(chunk(openAIDelta{Content: delta}, nil))
+	})
+	if err != nil {
+		if !started {
+			writeOpenAIUpstreamError(w, err)
+		}
+		return nil, err
+	}
+
+	finish := finishReason(resp)
+	final := chunk(openAIDelta{}, &finish)
+	final.Usage = &resp.Usage
+	if err := writeChunk(final); err != nil {
+		return nil, err
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+	return resp, nil
+}
+
+// logExchange records a proxied exchange, the request's messages and the
+// reply, as a conversation of userID so it shows up in the user's history.
+// Only the role and content of the client's messages are kept; anything
+// else they carry is the client's claim.
+func logExchange(userID primitive.ObjectID, request []llm.Message, reply llm.Message) {
+	messages := make([]llm.Message, 0, len(request)+1)
+	for _, m := range request {
+		messages = append(messages, llm.Message{Role: m.Role, Content: m.Content})
+	}
+	messages = append(messages, reply)
+
+	title := "API request"
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == llm.RoleUser {
+			title = conversationTitle(messages[i].Content)
+			break
+		}
+	}
+
+	if _, err := db.InsertConversation(database.Conversation{UserID: userID, Title: title, Messages: messages}); err != nil {
+		logger.Println("Error logging API exchange:", err)
+	}
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"askgo/llm"
+	"askgo/llm/llmtest"
+)
+
+// postOpenAI sends body to the chat completions endpoint with key.
+func postOpenAI(key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	newHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOpenAIChatCompletions(t *testing.T) {
+	mem, fake := newTestServer(t,
+		llmtest.Reply{Content: "Hi!", Usage: llm.Usage{PromptTokens: 4, CompletionTokens: 1, TotalTokens: 5}},
+		llmtest.Reply{Content: "A long", FinishReason: llm.FinishLength},
+	)
+	user := mem.addUser("ada@example.com")
+	key, _, _ := mem.CreateAPIKey(user.ID, "test")
+
+	// The client's claims about earlier answers are not stored
+	rec := postOpenAI(key, `{"messages":[
+		{"role":"system","content":"Be brief."},
+		{"role":"assistant","content":"Earlier","model":"gpt-9","provider":"forged","usage":{"total_tokens":999999}},
+		{"role":"user","content":"Hello"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message      map[string]interface{} `json:"message"`
+			FinishReason string                 `json:"finish_reason"`
+		} `json:"choices"`
+		Usage llm.Usage `json:"usage"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || len(resp.Choices) != 1 || resp.Choices[0].Message["content"] != "Hi!" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if resp.Choices[0].FinishReason != "stop" || resp.Usage.TotalTokens != 5 {
+		t.Errorf("finish reason %q usage %+v, want stop and the reply's usage", resp.Choices[0].FinishReason, resp.Usage)
+	}
+	if got := mem.calls["AuthenticateAPIKey"]; got != 1 {
+		t.Errorf("authenticated the key %d times for one request", got)
+	}
+
+	logged := mem.conversations[0]
+	if logged.Title != "Hello" || len(logged.Messages) != 4 {
+		t.Fatalf("logged %q with %+v", logged.Title, logged.Messages)
+	}
+	if earlier := logged.Messages[1]; earlier.Model != "" || earlier.Provider != "" || earlier.Usage != nil {
+		t.Errorf("stored the client's fields %+v", earlier)
+	}
+	if reply := logged.Messages[3]; reply.Content != "Hi!" || reply.Provider != llmtest.FakeName {
+		t.Errorf("stored reply %+v, want the backend's answer", reply)
+	}
+	if len(mem.usage) != 1 || mem.usage[0].PromptTokens != 4 {
+		t.Errorf("usage = %+v", mem.usage)
+	}
+
+	// Answers cut off at max_tokens say so
+	rec = postOpenAI(key, `{"messages":[{"role":"user","content":"Go on"}],"max_tokens":2}`)
+	if !strings.Contains(rec.Body.String(), `"finish_reason":"length"`) {
+		t.Errorf("truncated answer = %s, want finish_reason length", rec.Body)
+	}
+	if max := fake.Requests()[1].Params.MaxTokens; max == nil || *max != 2 {
+		t.Errorf("max_tokens = %v, want 2 passed upstream", max)
+	}
+}
+
+func TestOpenAIChatCompletionsStream(t *testing.T) {
+	mem, _ := newTestServer(t, llmtest.Reply{Chunks: []string{"Hel", "lo"}, FinishReason: llm.FinishLength})
+	user := mem.addUser("ada@example.com")
+	key, _, _ := mem.CreateAPIKey(user.ID, "test")
+
+	rec := postOpenAI(key, `{"messages":[{"role":"user","content":"Hi"}],"stream":true}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("POST = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	var events []string
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) != 5 || events[4] != "[DONE]" {
+		t.Fatalf("events = %q, want role, two deltas, the final chunk and [DONE]", events)
+	}
+	deltas := []string{`"delta":{"role":"assistant"}`, `"delta":{"content":"Hel"}`, `"delta":{"content":"lo"}`, `"delta":{}`}
+	for i, want := range deltas {
+		if !strings.Contains(events[i], want) {
+			t.Errorf("event %d = %s, want %s", i, events[i], want)
+		}
+	}
+	if !strings.Contains(events[3], `"finish_reason":"length"`) || !strings.Contains(events[2], `"finish_reason":null`) {
+		t.Errorf("finish reasons wrong in %q", events)
+	}
+	if got := mem.conversations[0].Messages; len(got) != 2 || got[1].Content != "Hello" {
+		t.Errorf("logged %+v", got)
+	}
+}
+
+func TestOpenAIChatCompletionsErrors(t *testing.T) {
+	mem, fake := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	key, _, _ := mem.CreateAPIKey(user.ID, "test")
+
+	tests := []struct {
+		name string
+		key  string
+		body string
+		fail string
+		want int
+	}{
+		{"unknown key", "askgo-nope", `{"messages":[{"role":"user","content":"Hi"}]}`, "", http.StatusUnauthorized},
+		{"store fails", key, `{"messages":[{"role":"user","content":"Hi"}]}`, "AuthenticateAPIKey", http.StatusInternalServerError},
+		{"malformed JSON", key, `{"messages":`, "", http.StatusBadRequest},
+		{"no messages", key, `{"messages":[]}`, "", http.StatusBadRequest},
+		{"bad parameter", key, `{"messages":[{"role":"user","content":"Hi"}],"temperature":5}`, "", http.StatusBadRequest},
+		{"too large", key, `{"messages":[{"role":"user","content":"` + strings.Repeat("a", maxMessageBytes) + `"}]}`, "", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fail != "" {
+				mem.fail[tt.fail] = errStore
+				defer delete(mem.fail, tt.fail)
+			}
+			rec := postOpenAI(tt.key, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("POST = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			var body openAIError
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Message == "" {
+				t.Errorf("body is not an OpenAI error: %v", err)
+			}
+		})
+	}
+	if n := len(fake.Requests()); n != 0 {
+		t.Errorf("sent %d invalid requests upstream", n)
+	}
+}
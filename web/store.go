@@ -0,0 +1,182 @@
+package web
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/persona"
+)
+
+// datastore is what the server keeps in the database. The handlers use it
+// through db, which tests replace with an in-memory fake.
+type datastore interface {
+	CreateUser(username, email, password string) (*database.User, error)
+	AuthenticateUser(email, password string) (*database.User, error)
+	GetUserByID(id primitive.ObjectID) (*database.User, error)
+	GetUserByEmail(email string) (*database.User, error)
+
+	CreateConversation(userID primitive.ObjectID, title string) (*database.Conversation, error)
+	InsertConversation(conversation database.Conversation) (*database.Conversation, error)
+	ListConversations(userID primitive.ObjectID, offset, limit int64) ([]database.Conversation, error)
+	GetConversation(userID, id primitive.ObjectID) (*database.Conversation, error)
+	SaveConversationMessages(userID, id primitive.ObjectID, messages []llm.Message) error
+	SaveConversationSummary(userID, id primitive.ObjectID, summary llm.Summary) error
+	RenameConversation(userID, id primitive.ObjectID, title string) error
+	SetConversationModel(userID, id primitive.ObjectID, provider, model string) error
+	SetConversationParams(userID, id primitive.ObjectID, params llm.Params) error
+	SetConversationPersona(userID, id primitive.ObjectID, name string) error
+	DeleteConversation(userID, id primitive.ObjectID) error
+
+	CreateAPIKey(userID primitive.ObjectID, name string) (string, *database.APIKey, error)
+	ListAPIKeys(userID primitive.ObjectID) ([]database.APIKey, error)
+	DeleteAPIKey(userID, id primitive.ObjectID) error
+	AuthenticateAPIKey(key string) (*database.User, error)
+
+	SavePersona(userID primitive.ObjectID, p persona.Persona) (*database.Persona, error)
+	ListPersonas(userID primitive.ObjectID) ([]database.Persona, error)
+	GetPersona(userID primitive.ObjectID, name string) (*database.Persona, error)
+	DeletePersona(userID primitive.ObjectID, name string) error
+
+	RecordUsage(userID primitive.ObjectID, provider, model string, usage llm.Usage) error
+	ListUsage(filter database.UsageFilter) ([]database.UsageRecord, error)
+
+	CountRequest(userID primitive.ObjectID, window time.Time) (int, error)
+	ReserveTokens(userID primitive.ObjectID, day string, tokens, limit int) (int, bool, error)
+	SettleTokens(userID primitive.ObjectID, day string, delta int) error
+	TokensUsed(userID primitive.ObjectID, day string) (int, error)
+	SetUserLimits(userID primitive.ObjectID, limits *database.Limits) error
+	ListLimitedUsers() ([]database.User, error)
+}
+
+// db is the server's datastore.
+var db datastore = mongoStore{}
+
+// mongoStore is the datastore of the database package, in MongoDB.
+type mongoStore struct{}
+
+func (mongoStore) CreateUser(username, email, password string) (*database.User, error) {
+	return database.CreateUser(username, email, password)
+}
+
+func (mongoStore) AuthenticateUser(email, password string) (*database.User, error) {
+	return database.AuthenticateUser(email, password)
+}
+
+func (mongoStore) GetUserByID(id primitive.ObjectID) (*database.User, error) {
+	return database.GetUserByID(id)
+}
+
+func (mongoStore) GetUserByEmail(email string) (*database.User, error) {
+	return database.GetUserByEmail(email)
+}
+
+func (mongoStore) CreateConversation(userID primitive.ObjectID, title string) (*database.Conversation, error) {
+	return database.CreateConversation(userID, title)
+}
+
+func (mongoStore) InsertConversation(conversation database.Conversation) (*database.Conversation, error) {
+	return database.InsertConversation(conversation)
+}
+
+func (mongoStore) ListConversations(userID primitive.ObjectID, offset, limit int64) ([]database.Conversation, error) {
+	return database.ListConversations(userID, offset, limit)
+}
+
+func (mongoStore) GetConversation(userID, id primitive.ObjectID) (*database.Conversation, error) {
+	return database.GetConversation(userID, id)
+}
+
+func (mongoStore) SaveConversationMessages(userID, id primitive.ObjectID, messages []llm.Message) error {
+	return database.SaveConversationMessages(userID, id, messages)
+}
+
+func (mongoStore) SaveConversationSummary(userID, id primitive.ObjectID, summary llm.Summary) error {
+	return database.SaveConversationSummary(userID, id, summary)
+}
+
+func (mongoStore) RenameConversation(userID, id primitive.ObjectID, title string) error {
+	return database.RenameConversation(userID, id, title)
+}
+
+func (mongoStore) SetConversationModel(userID, id primitive.ObjectID, provider, model string) error {
+	return database.SetConversationModel(userID, id, provider, model)
+}
+
+func (mongoStore) SetConversationParams(userID, id primitive.ObjectID, params llm.Params) error {
+	return database.SetConversationParams(userID, id, params)
+}
+
+func (mongoStore) SetConversationPersona(userID, id primitive.ObjectID, name string) error {
+	return database.SetConversationPersona(userID, id, name)
+}
+
+func (mongoStore) DeleteConversation(userID, id primitive.ObjectID) error {
+	return database.DeleteConversation(userID, id)
+}
+
+func (mongoStore) CreateAPIKey(userID primitive.ObjectID, name string) (string, *database.APIKey, error) {
+	return database.CreateAPIKey(userID, name)
+}
+
+func (mongoStore) ListAPIKeys(userID primitive.ObjectID) ([]database.APIKey, error) {
+	return database.ListAPIKeys(userID)
+}
+
+func (mongoStore) DeleteAPIKey(userID, id primitive.ObjectID) error {
+	return database.DeleteAPIKey(userID, id)
+}
+
+func (mongoStore) AuthenticateAPIKey(key string) (*database.User, error) {
+	return database.AuthenticateAPIKey(key)
+}
+
+func (mongoStore) SavePersona(userID primitive.ObjectID, p persona.Persona) (*database.Persona, error) {
+	return database.SavePersona(userID, p)
+}
+
+func (mongoStore) ListPersonas(userID primitive.ObjectID) ([]database.Persona, error) {
+	return database.ListPersonas(userID)
+}
+
+func (mongoStore) GetPersona(userID primitive.ObjectID, name string) (*database.Persona, error) {
+	return database.GetPersona(userID, name)
+}
+
+func (mongoStore) DeletePersona(userID primitive.ObjectID, name string) error {
+	return database.DeletePersona(userID, name)
+}
+
+func (mongoStore) RecordUsage(userID primitive.ObjectID, provider, model string, usage llm.Usage) error {
+	return database.RecordUsage(userID, provider, model, usage)
+}
+
+func (mongoStore) ListUsage(filter database.UsageFilter) ([]database.UsageRecord, error) {
+	return database.ListUsage(filter)
+}
+
+func (mongoStore) CountRequest(userID primitive.ObjectID, window time.Time) (int, error) {
+	return database.CountRequest(userID, window)
+}
+
+func (mongoStore) ReserveTokens(userID primitive.ObjectID, day string, tokens, limit int) (int, bool, error) {
+	return database.ReserveTokens(userID, day, tokens, limit)
+}
+
+func (mongoStore) SettleTokens(userID primitive.ObjectID, day string, delta int) error {
+	return database.SettleTokens(userID, day, delta)
+}
+
+func (mongoStore) TokensUsed(userID primitive.ObjectID, day string) (int, error) {
+	return database.TokensUsed(userID, day)
+}
+
+func (mongoStore) SetUserLimits(userID primitive.ObjectID, limits *database.Limits) error {
+	return database.SetUserLimits(userID, limits)
+}
+
+func (mongoStore) ListLimitedUsers() ([]database.User, error) {
+	return database.ListLimitedUsers()
+}
@@ -0,0 +1,474 @@
+package web
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/persona"
+)
+
+// memStore is an in-memory datastore behaving like mongoStore.
+type memStore struct {
+	mu            sync.Mutex
+	users         []database.User
+	conversations []database.Conversation
+	apiKeys       map[string]database.APIKey
+	personas      []database.Persona
+	usage         []database.UsageRecord
+	requests      map[string]int
+	tokens        map[string]int
+	// fail holds errors injected into the calls of a method
+	fail map[string]error
+	// calls counts the calls of each method
+	calls map[string]int
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		apiKeys:  make(map[string]database.APIKey),
+		requests: make(map[string]int),
+		tokens:   make(map[string]int),
+		fail:     make(map[string]error),
+		calls:    make(map[string]int),
+	}
+}
+
+// call records a call of method and returns the error injected into it.
+func (m *memStore) call(method string) error {
+	m.calls[method]++
+	return m.fail[method]
+}
+
+// addUser creates a user with email, whose password is "secret".
+func (m *memStore) addUser(email string) *database.User {
+	user, err := m.CreateUser(strings.Split(email, "@")[0], email, "secret")
+	if err != nil {
+		panic(err)
+	}
+	return user
+}
+
+func (m *memStore) CreateUser(username, email, password string) (*database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("CreateUser"); err != nil {
+		return nil, err
+	}
+	user := database.User{ID: primitive.NewObjectID(), Username: username, Email: email, Password: password, CreatedAt: time.Now()}
+	m.users = append(m.users, user)
+	return &user, nil
+}
+
+func (m *memStore) findUser(match func(*database.User) bool) (*database.User, error) {
+	for i := range m.users {
+		if match(&m.users[i]) {
+			user := m.users[i]
+			return &user, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memStore) AuthenticateUser(email, password string) (*database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("AuthenticateUser"); err != nil {
+		return nil, err
+	}
+	return m.findUser(func(u *database.User) bool { return u.Email == email && u.Password == password })
+}
+
+func (m *memStore) GetUserByID(id primitive.ObjectID) (*database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("GetUserByID"); err != nil {
+		return nil, err
+	}
+	return m.findUser(func(u *database.User) bool { return u.ID == id })
+}
+
+func (m *memStore) GetUserByEmail(email string) (*database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("GetUserByEmail"); err != nil {
+		return nil, err
+	}
+	return m.findUser(func(u *database.User) bool { return u.Email == email })
+}
+
+func (m *memStore) CreateConversation(userID primitive.ObjectID, title string) (*database.Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("CreateConversation"); err != nil {
+		return nil, err
+	}
+	return m.insert(database.Conversation{UserID: userID, Title: title})
+}
+
+func (m *memStore) InsertConversation(conversation database.Conversation) (*database.Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("InsertConversation"); err != nil {
+		return nil, err
+	}
+	return m.insert(conversation)
+}
+
+func (m *memStore) insert(conv database.Conversation) (*database.Conversation, error) {
+	now := time.Now()
+	conv.ID, conv.CreatedAt, conv.UpdatedAt = primitive.NewObjectID(), now, now
+	conv.Messages = append(database.Messages{}, conv.Messages...)
+	m.conversations = append(m.conversations, conv)
+	return &conv, nil
+}
+
+func (m *memStore) ListConversations(userID primitive.ObjectID, offset, limit int64) ([]database.Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ListConversations"); err != nil {
+		return nil, err
+	}
+	list := []database.Conversation{}
+	for _, c := range m.conversations {
+		if c.UserID == userID {
+			c.Messages = nil
+			list = append(list, c)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	list = list[min(int(offset), len(list)):]
+	return list[:min(int(limit), len(list))], nil
+}
+
+// conversation returns the stored conversation id of userID.
+func (m *memStore) conversation(userID, id primitive.ObjectID) (*database.Conversation, error) {
+	for i := range m.conversations {
+		if m.conversations[i].ID == id && m.conversations[i].UserID == userID {
+			return &m.conversations[i], nil
+		}
+	}
+	return nil, database.ErrConversationNotFound
+}
+
+func (m *memStore) GetConversation(userID, id primitive.ObjectID) (*database.Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("GetConversation"); err != nil {
+		return nil, err
+	}
+	stored, err := m.conversation(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	conv := *stored
+	conv.Messages = append([]llm.Message(nil), stored.Messages...)
+	return &conv, nil
+}
+
+// update applies set to conversation id of userID.
+func (m *memStore) update(method string, userID, id primitive.ObjectID, set func(*database.Conversation)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call(method); err != nil {
+		return err
+	}
+	conv, err := m.conversation(userID, id)
+	if err != nil {
+		return err
+	}
+	set(conv)
+	conv.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *memStore) SaveConversationMessages(userID, id primitive.ObjectID, messages []llm.Message) error {
+	return m.update("SaveConversationMessages", userID, id, func(c *database.Conversation) {
+		c.Messages = append([]llm.Message(nil), messages...)
+	})
+}
+
+func (m *memStore) SaveConversationSummary(userID, id primitive.ObjectID, summary llm.Summary) error {
+	return m.update("SaveConversationSummary", userID, id, func(c *database.Conversation) { c.Summary = summary })
+}
+
+func (m *memStore) RenameConversation(userID, id primitive.ObjectID, title string) error {
+	return m.update("RenameConversation", userID, id, func(c *database.Conversation) { c.Title = title })
+}
+
+func (m *memStore) SetConversationModel(userID, id primitive.ObjectID, provider, model string) error {
+	return m.update("SetConversationModel", userID, id, func(c *database.Conversation) { c.Provider, c.Model = provider, model })
+}
+
+func (m *memStore) SetConversationParams(userID, id primitive.ObjectID, params llm.Params) error {
+	return m.update("SetConversationParams", userID, id, func(c *database.Conversation) { c.Params = params })
+}
+
+func (m *memStore) SetConversationPersona(userID, id primitive.ObjectID, name string) error {
+	return m.update("SetConversationPersona", userID, id, func(c *database.Conversation) { c.Persona = name })
+}
+
+func (m *memStore) DeleteConversation(userID, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("DeleteConversation"); err != nil {
+		return err
+	}
+	for i, c := range m.conversations {
+		if c.ID == id && c.UserID == userID {
+			m.conversations = append(m.conversations[:i], m.conversations[i+1:]...)
+			return nil
+		}
+	}
+	return database.ErrConversationNotFound
+}
+
+func (m *memStore) CreateAPIKey(userID primitive.ObjectID, name string) (string, *database.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("CreateAPIKey"); err != nil {
+		return "", nil, err
+	}
+	key := "askgo-" + primitive.NewObjectID().Hex()
+	stored := database.APIKey{ID: primitive.NewObjectID(), UserID: userID, Name: name, Hint: key[:10] + "...", CreatedAt: time.Now()}
+	m.apiKeys[key] = stored
+	return key, &stored, nil
+}
+
+func (m *memStore) ListAPIKeys(userID primitive.ObjectID) ([]database.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ListAPIKeys"); err != nil {
+		return nil, err
+	}
+	keys := []database.APIKey{}
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memStore) DeleteAPIKey(userID, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("DeleteAPIKey"); err != nil {
+		return err
+	}
+	for key, k := range m.apiKeys {
+		if k.ID == id && k.UserID == userID {
+			delete(m.apiKeys, key)
+			return nil
+		}
+	}
+	return database.ErrAPIKeyNotFound
+}
+
+func (m *memStore) AuthenticateAPIKey(key string) (*database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("AuthenticateAPIKey"); err != nil {
+		return nil, err
+	}
+	stored, ok := m.apiKeys[key]
+	if !ok {
+		return nil, database.ErrAPIKeyNotFound
+	}
+	stored.LastUsedAt = time.Now()
+	m.apiKeys[key] = stored
+	return m.findUser(func(u *database.User) bool { return u.ID == stored.UserID })
+}
+
+func (m *memStore) SavePersona(userID primitive.ObjectID, p persona.Persona) (*database.Persona, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("SavePersona"); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range m.personas {
+		if m.personas[i].UserID == userID && m.personas[i].Name == p.Name {
+			m.personas[i].Persona, m.personas[i].UpdatedAt = p, now
+			saved := m.personas[i]
+			return &saved, nil
+		}
+	}
+	saved := database.Persona{ID: primitive.NewObjectID(), UserID: userID, Persona: p, CreatedAt: now, UpdatedAt: now}
+	m.personas = append(m.personas, saved)
+	return &saved, nil
+}
+
+func (m *memStore) ListPersonas(userID primitive.ObjectID) ([]database.Persona, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ListPersonas"); err != nil {
+		return nil, err
+	}
+	list := []database.Persona{}
+	for _, p := range m.personas {
+		if p.UserID == userID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (m *memStore) GetPersona(userID primitive.ObjectID, name string) (*database.Persona, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("GetPersona"); err != nil {
+		return nil, err
+	}
+	for _, p := range m.personas {
+		if p.UserID == userID && p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, database.ErrPersonaNotFound
+}
+
+func (m *memStore) DeletePersona(userID primitive.ObjectID, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("DeletePersona"); err != nil {
+		return err
+	}
+	for i, p := range m.personas {
+		if p.UserID == userID && p.Name == name {
+			m.personas = append(m.personas[:i], m.personas[i+1:]...)
+			return nil
+		}
+	}
+	return database.ErrPersonaNotFound
+}
+
+func (m *memStore) RecordUsage(userID primitive.ObjectID, provider, model string, usage llm.Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("RecordUsage"); err != nil {
+		return err
+	}
+	day := time.Now().UTC().Format(database.DayFormat)
+	for i := range m.usage {
+		r := &m.usage[i]
+		if r.UserID == userID && r.Day == day && r.Provider == provider && r.Model == model {
+			r.Requests++
+			r.PromptTokens += usage.PromptTokens
+			r.CompletionTokens += usage.CompletionTokens
+			return nil
+		}
+	}
+	m.usage = append(m.usage, database.UsageRecord{
+		UserID: userID, Day: day, Provider: provider, Model: model,
+		Requests: 1, PromptTokens: usage.PromptTokens, CompletionTokens: usage.CompletionTokens,
+	})
+	return nil
+}
+
+func (m *memStore) ListUsage(filter database.UsageFilter) ([]database.UsageRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ListUsage"); err != nil {
+		return nil, err
+	}
+	records := []database.UsageRecord{}
+	for _, r := range m.usage {
+		if (filter.UserID.IsZero() || r.UserID == filter.UserID) &&
+			(filter.From == "" || r.Day >= filter.From) && (filter.To == "" || r.Day <= filter.To) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (m *memStore) CountRequest(userID primitive.ObjectID, window time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("CountRequest"); err != nil {
+		return 0, err
+	}
+	key := userID.Hex() + window.Format(time.RFC3339)
+	m.requests[key]++
+	return m.requests[key], nil
+}
+
+func (m *memStore) ReserveTokens(userID primitive.ObjectID, day string, tokens, limit int) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ReserveTokens"); err != nil {
+		return 0, false, err
+	}
+	key := userID.Hex() + day
+	if limit > 0 && m.tokens[key]+tokens > limit {
+		return m.tokens[key], false, nil
+	}
+	m.tokens[key] += tokens
+	return m.tokens[key], true, nil
+}
+
+func (m *memStore) SettleTokens(userID primitive.ObjectID, day string, delta int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("SettleTokens"); err != nil {
+		return err
+	}
+	m.tokens[userID.Hex()+day] += delta
+	return nil
+}
+
+func (m *memStore) TokensUsed(userID primitive.ObjectID, day string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("TokensUsed"); err != nil {
+		return 0, err
+	}
+	return m.tokens[userID.Hex()+day], nil
+}
+
+func (m *memStore) SetUserLimits(userID primitive.ObjectID, limits *database.Limits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("SetUserLimits"); err != nil {
+		return err
+	}
+	for i := range m.users {
+		if m.users[i].ID == userID {
+			if limits != nil {
+				copied := *limits
+				limits = &copied
+			}
+			m.users[i].Limits = limits
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *memStore) ListLimitedUsers() ([]database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.call("ListLimitedUsers"); err != nil {
+		return nil, err
+	}
+	users := []database.User{}
+	for _, u := range m.users {
+		if u.Limits != nil {
+			u.Password = ""
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users, nil
+}
+
+// errStore is the error tests inject with memStore.fail.
+var errStore = errors.New("store unavailable")
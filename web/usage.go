@@ -0,0 +1,179 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"askgo/database"
+)
+
+// monthFormat is the layout of the month shown on the usage page.
+const monthFormat = "2006-01"
+
+// UsagePageData fills templates/usage.html.
+type UsagePageData struct {
+	User *database.User
+	// Month is the month shown, Prev and Next link to its neighbours;
+	// Next is empty for the current month.
+	Month string
+	Prev  string
+	Next  string
+
+	ByDay   []database.UsageTotal
+	ByModel []database.UsageTotal
+	Total   database.UsageTotal
+	Error   string
+}
+
+type apiUsageTotal struct {
+	Key              string  `json:"key"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	// Unpriced marks totals including models of unknown price.
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+type apiUsage struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Group  string          `json:"group"`
+	Totals []apiUsageTotal `json:"totals"`
+	Total  apiUsageTotal   `json:"total"`
+}
+
+// usageCost prices a usage record with the server's price table.
+func usageCost(r database.UsageRecord) (float64, bool) {
+	return llmClient.Cost(r.Provider, r.Model, r.Usage())
+}
+
+// usageKeys group usage records on the usage page and in the API.
+var usageKeys = map[string]func(database.UsageRecord) string{
+	"day":   func(r database.UsageRecord) string { return r.Day },
+	"model": func(r database.UsageRecord) string { return r.Provider + ":" + r.Model },
+	"all":   func(database.UsageRecord) string { return "total" },
+}
+
+// usageTotal sums all of records into one total.
+func usageTotal(records []database.UsageRecord) database.UsageTotal {
+	totals := database.TotalUsage(records, usageKeys["all"], usageCost)
+	if len(totals) == 0 {
+		return database.UsageTotal{Key: "total"}
+	}
+	return totals[0]
+}
+
+// handleUsage shows the signed-in user's token usage and estimated cost
+// for one month, ?month=YYYY-MM, the current one by default.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	month, err := time.Parse(monthFormat, r.URL.Query().Get("month"))
+	if err != nil || month.After(thisMonth) {
+		month = thisMonth
+	}
+
+	data := UsagePageData{
+		User:  user,
+		Month: month.Format(monthFormat),
+		Prev:  month.AddDate(0, -1, 0).Format(monthFormat),
+	}
+	if month.Before(thisMonth) {
+		data.Next = month.AddDate(0, 1, 0).Format(monthFormat)
+	}
+
+	records, err := db.ListUsage(database.UsageFilter{
+		UserID: user.ID,
+		From:   month.Format(database.DayFormat),
+		To:     month.AddDate(0, 1, -1).Format(database.DayFormat),
+	})
+	if err != nil {
+		logger.Println("Error loading usage:", err)
+		data.Error = "Could not load usage"
+	}
+	data.ByDay = database.TotalUsage(records, usageKeys["day"], usageCost)
+	data.ByModel = database.TotalUsage(records, usageKeys["model"], usageCost)
+	data.Total = usageTotal(records)
+
+	tmpl := template.Must(template.New("usage.html").Funcs(templateFuncs).ParseFiles("templates/usage.html"))
+	tmpl.Execute(w, data)
+}
+
+func toAPIUsageTotal(t database.UsageTotal) apiUsageTotal {
+	return apiUsageTotal{
+		Key:              t.Key,
+		Requests:         t.Requests,
+		PromptTokens:     t.Usage.PromptTokens,
+		CompletionTokens: t.Usage.CompletionTokens,
+		TotalTokens:      t.Usage.TotalTokens,
+		CostUSD:          t.Cost,
+		Unpriced:         t.Unpriced,
+	}
+}
+
+// handleAPIUsage reports the caller's token usage and estimated cost:
+//
+//	GET /api/v1/usage?from=YYYY-MM-DD&to=YYYY-MM-DD&group=day|model
+//
+// The range defaults to the current month so far, grouped by day.
+func handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Login required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	result := apiUsage{
+		From:  now.Format(monthFormat) + "-01",
+		To:    now.Format(database.DayFormat),
+		Group: "day",
+	}
+	for _, field := range []struct {
+		name string
+		dest *string
+	}{{"from", &result.From}, {"to", &result.To}} {
+		if value := query.Get(field.name); value != "" {
+			if _, err := time.Parse(database.DayFormat, value); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "bad_request", field.name+" must be a date like 2006-01-02")
+				return
+			}
+			*field.dest = value
+		}
+	}
+	if group := query.Get("group"); group != "" {
+		if _, ok := usageKeys[group]; !ok || group == "all" {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "group must be day or model")
+			return
+		}
+		result.Group = group
+	}
+
+	records, err := db.ListUsage(database.UsageFilter{UserID: user.ID, From: result.From, To: result.To})
+	if err != nil {
+		logger.Println("Error loading usage:", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Error loading usage")
+		return
+	}
+
+	result.Totals = []apiUsageTotal{}
+	for _, t := range database.TotalUsage(records, usageKeys[result.Group], usageCost) {
+		result.Totals = append(result.Totals, toAPIUsageTotal(t))
+	}
+	result.Total = toAPIUsageTotal(usageTotal(records))
+	writeJSON(w, http.StatusOK, result)
+}
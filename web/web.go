@@ -0,0 +1,638 @@
+// Package web serves the browser front-end and its WebSocket hub.
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/persona"
+	"github.com/gorilla/sessions"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// wsEvent is the JSON frame pushed to WebSocket clients while a reply
+// streams in. Type is "delta" for each fragment, then "done" with the full
+// reply or "error".
+type wsEvent struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	Content        string `json:"content"`
+}
+
+type PageData struct {
+	Messages       []llm.Message
+	User           *database.User
+	Error          string
+	Conversations  []database.Conversation
+	ConversationID string
+	Page           int
+	HasMore        bool
+	// Models fills the model picker, Model is the selected entry.
+	Models []llm.Target
+	Model  llm.Target
+	// Params are the sampling parameters of the open conversation.
+	Params llm.Params
+	// Personas fill the persona picker, Persona is the selected name.
+	Personas []persona.Persona
+	Persona  string
+}
+
+// conversationsPerPage is the number of conversations listed in the sidebar.
+const conversationsPerPage = 20
+
+var (
+	conversations = newConversationStore()
+	llmClient     *llm.Client
+	// logger receives the server's log, Config.Logger when set
+	logger = log.Default()
+	// serverPersonas are shared by all users; users' own personas with
+	// the same name take precedence
+	serverPersonas []persona.Persona
+	store          = sessions.NewCookieStore([]byte("your-secret-key"))
+	upgrader       = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	// clients maps each open WebSocket to the user it belongs to
+	clients   = make(map[*websocket.Conn]primitive.ObjectID)
+	clientsMu sync.Mutex
+)
+
+// Add these template functions
+var templateFuncs = template.FuncMap{
+	"add":           func(a, b int) int { return a + b },
+	"contains":      strings.Contains,
+	"trimPrefix":    strings.TrimPrefix,
+	"formatMessage": formatMessage,
+	"dollars":       func(v float64) string { return fmt.Sprintf("$%.4f", v) },
+}
+
+// Add this function to format messages
+func formatMessage(content string) template.HTML {
+	// Warning: Be careful with template.HTML as it bypasses XSS protection
+	// In a production environment, you should properly sanitize the content
+	return template.HTML(content)
+}
+
+// Config configures the web server.
+type Config struct {
+	// Addr is the listen address, ":8080" when empty.
+	Addr string
+	LLM  llm.Config
+	// Client, when set, is used instead of a client built from LLM, e.g.
+	// an llmtest fake.
+	Client *llm.Client
+	// Personas are offered to every user in addition to their own.
+	Personas []persona.Persona
+	// Limits cap the requests and tokens of each user, DefaultLimits
+	// when nil. Admins, listed by email, can override them per user.
+	Limits *database.Limits
+	Admins []string
+	// Logger receives server errors, log.Default() when nil.
+	Logger *log.Logger
+}
+
+// Start connects to the database and serves the web interface until the
+// listener fails.
+func Start(cfg Config) error {
+	if cfg.Addr == "" {
+		cfg.Addr = ":8080"
+	}
+
+	// Initialize database
+	if err := database.InitDB(); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	defer database.CloseDB()
+
+	// Initialize LLM client
+	llmClient = cfg.Client
+	if llmClient == nil {
+		llmClient = llm.NewClient(cfg.LLM)
+	}
+	serverPersonas = cfg.Personas
+	if cfg.Logger != nil {
+		logger = cfg.Logger
+	}
+	if cfg.Limits != nil {
+		serverLimits = *cfg.Limits
+	}
+	for _, email := range cfg.Admins {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	// Start server
+	logger.Println("Starting server on http://localhost" + displayPort(cfg.Addr))
+	return http.ListenAndServe(cfg.Addr, newHandler())
+}
+
+// newHandler returns the server's routes. The per-user limits are
+// enforced by the handlers that call a model, see reserve.
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Serve static files
+	fs := http.FileServer(http.Dir("static"))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	// Handle routes
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/signup", handleSignup)
+	mux.HandleFunc("/logout", handleLogout)
+	mux.HandleFunc("/chat", handleChat)
+	mux.HandleFunc("/new-chat", handleNewChat)
+	mux.HandleFunc("/conversations/rename", handleRenameConversation)
+	mux.HandleFunc("/conversations/delete", handleDeleteConversation)
+	mux.HandleFunc("/ws", handleWebSocket)
+	mux.HandleFunc("/usage", handleUsage)
+
+	// JSON API
+	mux.HandleFunc(apiPrefix, handleAPIConversations)
+	mux.HandleFunc(apiPrefix+"/", handleAPIConversations)
+	mux.HandleFunc("/api/v1/keys", handleAPIKeys)
+	mux.HandleFunc("/api/v1/keys/", handleAPIKeys)
+	mux.HandleFunc("/api/v1/models", handleAPIModels)
+	mux.HandleFunc("/api/v1/personas", handleAPIPersonas)
+	mux.HandleFunc("/api/v1/personas/", handleAPIPersonas)
+	mux.HandleFunc("/api/v1/usage", handleAPIUsage)
+	mux.HandleFunc("/api/v1/limits", handleAPILimits)
+	mux.HandleFunc("/api/v1/admin/limits", handleAPIAdminLimits)
+	mux.HandleFunc("/api/v1/admin/limits/", handleAPIAdminLimits)
+
+	// OpenAI-compatible gateway
+	mux.HandleFunc("/v1/chat/completions", handleOpenAIChatCompletions)
+	mux.HandleFunc("/v1/models", handleOpenAIModels)
+
+	return mux
+}
+
+// displayPort returns the ":port" suffix of addr for the startup banner.
+func displayPort(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		return addr[i:]
+	}
+	return addr
+}
+
+func getUserFromSession(r *http.Request) *database.User {
+	session, _ := store.Get(r, "session")
+	if userID, ok := session.Values["user_id"].(string); ok {
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return nil
+		}
+		user, err := db.GetUserByID(objID)
+		if err != nil {
+			return nil
+		}
+		return user
+	}
+	return nil
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		tmpl := template.Must(template.ParseFiles("templates/login.html"))
+		tmpl.Execute(w, PageData{})
+		return
+	}
+
+	email := r.FormValue("email")
+	password := r.FormValue("password")
+
+	user, err := db.AuthenticateUser(email, password)
+	if err != nil {
+		tmpl := template.Must(template.ParseFiles("templates/login.html"))
+		tmpl.Execute(w, PageData{Error: "Invalid email or password"})
+		return
+	}
+
+	session, _ := store.Get(r, "session")
+	session.Values["user_id"] = user.ID.Hex()
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleSignup(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+		tmpl.Execute(w, PageData{})
+		return
+	}
+
+	username := r.FormValue("username")
+	email := r.FormValue("email")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	if password != confirmPassword {
+		tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+		tmpl.Execute(w, PageData{Error: "Passwords do not match"})
+		return
+	}
+
+	user, err := db.CreateUser(username, email, password)
+	if err != nil {
+		tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+		tmpl.Execute(w, PageData{Error: "Error creating user"})
+		return
+	}
+
+	session, _ := store.Get(r, "session")
+	session.Values["user_id"] = user.ID.Hex()
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session")
+	if userID, ok := session.Values["user_id"].(string); ok {
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err == nil {
+			// History is saved after every turn, so only the cache is dropped
+			conversations.evict(objID)
+		}
+	}
+
+	// Clear session
+	delete(session.Values, "user_id")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Create template with functions
+	tmpl := template.Must(template.New("index.html").Funcs(templateFuncs).ParseFiles("templates/index.html"))
+
+	data := PageData{User: user}
+
+	// List a page of the user's conversations for the sidebar
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 0 {
+		page = 0
+	}
+	status := http.StatusOK
+	list, err := db.ListConversations(user.ID, int64(page*conversationsPerPage), conversationsPerPage+1)
+	if err != nil {
+		logger.Println("Error listing conversations:", err)
+		status, data.Error = http.StatusInternalServerError, "Your conversations could not be loaded. Try again later."
+	}
+	if len(list) > conversationsPerPage {
+		list, data.HasMore = list[:conversationsPerPage], true
+	}
+	data.Conversations = list
+	data.Page = page
+
+	// Open the selected conversation, if any. Failures are shown on the
+	// page; redirecting would hide them or loop.
+	id := r.URL.Query().Get("c")
+	var conv *conversation
+	if id != "" {
+		conv, err = loadConversation(user.ID, id)
+		if errors.Is(err, database.ErrConversationNotFound) {
+			status, data.Error = http.StatusNotFound, "This conversation does not exist or was deleted."
+		} else if err != nil {
+			logger.Println("Error loading conversation:", err)
+			status, data.Error = http.StatusInternalServerError, "This conversation could not be loaded. Try again later."
+		}
+	}
+	if conv != nil {
+		data.ConversationID = id
+		data.Messages = conv.snapshot()
+		data.Params, data.Persona = conv.settings()
+		var p persona.Persona
+		if data.Persona != "" {
+			p, _ = findPersona(user.ID, data.Persona)
+		}
+		data.Model = conv.target(p)
+	} else {
+		data.Model = defaultTarget()
+	}
+
+	data.Personas = listPersonas(user.ID)
+
+	// Keep the selection visible even if its backend is unreachable
+	data.Models = modelCatalog(r.Context())
+	found := false
+	for _, target := range data.Models {
+		found = found || target == data.Model
+	}
+	if !found {
+		data.Models = append([]llm.Target{data.Model}, data.Models...)
+	}
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// loadConversation resolves a hex conversation ID owned by userID.
+func loadConversation(userID primitive.ObjectID, id string) (*conversation, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, database.ErrConversationNotFound
+	}
+	return conversations.get(userID, objID)
+}
+
+// defaultTarget is the backend answering in conversations without a model
+// choice.
+func defaultTarget() llm.Target {
+	return llm.Target{Provider: llmClient.Provider(), Model: llmClient.Model()}
+}
+
+// modelCatalog lists the models of all backends for the model pickers.
+// Backends that cannot be reached are logged and left out.
+func modelCatalog(ctx context.Context) []llm.Target {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	catalog, err := llmClient.Catalog(ctx)
+	if err != nil {
+		logger.Println("Error listing models:", err)
+	}
+	return catalog
+}
+
+// findPersona returns userID's persona called name, falling back to the
+// server's personas.
+func findPersona(userID primitive.ObjectID, name string) (persona.Persona, error) {
+	stored, err := db.GetPersona(userID, name)
+	if err == nil {
+		return stored.Persona, nil
+	} else if err != database.ErrPersonaNotFound {
+		return persona.Persona{}, err
+	}
+	return persona.Find(serverPersonas, name)
+}
+
+// listPersonas returns the server's personas overlaid with userID's own,
+// sorted by name.
+func listPersonas(userID primitive.ObjectID) []persona.Persona {
+	byName := make(map[string]persona.Persona)
+	for _, p := range serverPersonas {
+		byName[p.Name] = p
+	}
+	stored, err := db.ListPersonas(userID)
+	if err != nil {
+		logger.Println("Error listing personas:", err)
+	}
+	for _, p := range stored {
+		byName[p.Name] = p.Persona
+	}
+
+	personas := make([]persona.Persona, 0, len(byName))
+	for _, p := range byName {
+		personas = append(personas, p)
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+	return personas
+}
+
+// conversationTitle derives a sidebar title from the first message of a
+// conversation.
+func conversationTitle(message string) string {
+	title := strings.TrimSpace(message)
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = strings.TrimSpace(title[:i])
+	}
+	if runes := []rune(title); len(runes) > 40 {
+		title = string(runes[:40]) + "…"
+	}
+	return title
+}
+
+func handleChat(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userMessage := r.FormValue("message")
+	if userMessage == "" {
+		http.Error(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+
+	// Continue the given conversation or start a new one
+	conversationID := r.FormValue("conversation_id")
+	if conversationID == "" {
+		created, err := db.CreateConversation(user.ID, conversationTitle(userMessage))
+		if err != nil {
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+		conversationID = created.ID.Hex()
+	}
+	conv, err := loadConversation(user.ID, conversationID)
+	if err == database.ErrConversationNotFound {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error loading chat history", http.StatusInternalServerError)
+		return
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	if _, err := sendMessage(r.Context(), user, conv, userMessage, llm.Options{}); err != nil {
+		var exceeded *limitError
+		if errors.As(err, &exceeded) {
+			writeLimitError(w, exceeded)
+			return
+		}
+		status, _ := upstreamStatus(err)
+		http.Error(w, llm.UserMessage(err), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"conversation_id": conversationID})
+}
+
+// Add this helper function to format AI responses
+func formatAIResponse(response string) string {
+	// You can add more formatting logic here if needed
+	return response
+}
+
+func handleNewChat(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	created, err := db.CreateConversation(user.ID, "")
+	if err != nil {
+		http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/?c="+created.ID.Hex(), http.StatusSeeOther)
+}
+
+func handleRenameConversation(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		http.Error(w, "Title is required", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := loadConversation(user.ID, r.FormValue("id"))
+	if err == database.ErrConversationNotFound {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error loading conversation", http.StatusInternalServerError)
+		return
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	if err := db.RenameConversation(user.ID, conv.id, title); err != nil {
+		http.Error(w, "Error renaming conversation", http.StatusInternalServerError)
+		return
+	}
+	conv.title = title
+
+	http.Redirect(w, r, "/?c="+conv.id.Hex(), http.StatusSeeOther)
+}
+
+func handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conv, err := loadConversation(user.ID, r.FormValue("id"))
+	if err == database.ErrConversationNotFound {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error loading conversation", http.StatusInternalServerError)
+		return
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	if err := db.DeleteConversation(user.ID, conv.id); err != nil && err != database.ErrConversationNotFound {
+		http.Error(w, "Error deleting conversation", http.StatusInternalServerError)
+		return
+	}
+	conversations.remove(user.ID, conv.id)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Println("Error upgrading to WebSocket:", err)
+		return
+	}
+
+	clientsMu.Lock()
+	clients[conn] = user.ID
+	clientsMu.Unlock()
+
+	defer func() {
+		clientsMu.Lock()
+		delete(clients, conn)
+		clientsMu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+}
+
+// broadcastMessage sends message to every WebSocket opened by userID.
+func broadcastMessage(userID primitive.ObjectID, message string) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	for client, owner := range clients {
+		if owner != userID {
+			continue
+		}
+		err := client.WriteMessage(websocket.TextMessage, []byte(message))
+		if err != nil {
+			client.Close()
+			delete(clients, client)
+		}
+	}
+}
+
+func broadcastEvent(userID primitive.ObjectID, event wsEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Println("Error encoding WebSocket event:", err)
+		return
+	}
+	broadcastMessage(userID, string(data))
+}
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"askgo/database"
+	"askgo/llm"
+	"askgo/llm/llmtest"
+)
+
+// newTestServer points the server's globals at an in-memory store and a
+// fake backend answering with replies, and returns both. Limits are off
+// unless the test sets serverLimits.
+func newTestServer(t *testing.T, replies ...llmtest.Reply) (*memStore, *llmtest.FakeProvider) {
+	t.Helper()
+	mem, fake := newMemStore(), llmtest.NewFakeProvider(replies...)
+
+	oldDB, oldClient, oldConversations := db, llmClient, conversations
+	oldLimits, oldAdmins := serverLimits, admins
+	db, llmClient, conversations = mem, llmtest.NewClient(fake), newConversationStore()
+	serverLimits, admins = database.Limits{}, make(map[string]bool)
+	t.Cleanup(func() {
+		db, llmClient, conversations = oldDB, oldClient, oldConversations
+		serverLimits, admins = oldLimits, oldAdmins
+	})
+	return mem, fake
+}
+
+// captureLog sends the server's log to the returned buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	oldLogger := logger
+	logger = log.New(&logs, "", 0)
+	t.Cleanup(func() { logger = oldLogger })
+	return &logs
+}
+
+// login returns the session cookie of user.
+func login(t *testing.T, user *database.User) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "session")
+	session.Values["user_id"] = user.ID.Hex()
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// postForm sends form to path through the server's handler.
+func postForm(cookie *http.Cookie, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	newHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleChat(t *testing.T) {
+	mem, fake := newTestServer(t,
+		llmtest.Reply{Content: "Hi there", Usage: llm.Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}},
+		llmtest.Reply{Content: "Still here"},
+	)
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+
+	rec := postForm(cookie, "/chat", url.Values{"message": {"Hello\nwith a second line"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /chat = %d %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.ConversationID == "" {
+		t.Fatalf("response %q lacks the conversation ID: %v", rec.Body, err)
+	}
+
+	conversation := mem.conversations[0]
+	if conversation.ID.Hex() != body.ConversationID || conversation.UserID != user.ID {
+		t.Errorf("stored conversation %s of %s, want %s of the user", conversation.ID.Hex(), conversation.UserID.Hex(), body.ConversationID)
+	}
+	if conversation.Title != "Hello" {
+		t.Errorf("title = %q, want the first line of the message", conversation.Title)
+	}
+	if len(conversation.Messages) != 2 || conversation.Messages[1].Content != "Hi there" || conversation.Messages[1].Provider != llmtest.FakeName {
+		t.Errorf("stored messages = %+v, want the prompt and the answer", conversation.Messages)
+	}
+	if len(mem.usage) != 1 || mem.usage[0].PromptTokens != 5 || mem.usage[0].CompletionTokens != 2 {
+		t.Errorf("usage = %+v, want the tokens of the answer", mem.usage)
+	}
+
+	// The next message continues the conversation with its history
+	rec = postForm(cookie, "/chat", url.Values{"message": {"Are you there?"}, "conversation_id": {body.ConversationID}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second POST /chat = %d %s", rec.Code, rec.Body)
+	}
+	requests := fake.Requests()
+	if got := len(requests[1].Messages); got != 3 {
+		t.Errorf("second request sent %d messages, want the history and the prompt", got)
+	}
+	if got := len(mem.conversations[0].Messages); got != 4 {
+		t.Errorf("stored %d messages after two turns, want 4", got)
+	}
+}
+
+func TestHandleChatErrors(t *testing.T) {
+	mem, _ := newTestServer(t, llmtest.Reply{Err: &llm.StatusError{StatusCode: http.StatusBadRequest, Kind: llm.ErrBadRequest}})
+	user := mem.addUser("ada@example.com")
+	other := mem.addUser("bob@example.com")
+	cookie := login(t, user)
+	foreign, _ := mem.CreateConversation(other.ID, "Bob's")
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		form   url.Values
+		want   int
+	}{
+		{"signed out", nil, url.Values{"message": {"hi"}}, http.StatusUnauthorized},
+		{"no message", cookie, url.Values{}, http.StatusBadRequest},
+		{"malformed conversation", cookie, url.Values{"message": {"hi"}, "conversation_id": {"nope"}}, http.StatusNotFound},
+		{"conversation of another user", cookie, url.Values{"message": {"hi"}, "conversation_id": {foreign.ID.Hex()}}, http.StatusNotFound},
+		{"backend refuses", cookie, url.Values{"message": {"hi"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postForm(tt.cookie, "/chat", tt.form); rec.Code != tt.want {
+				t.Errorf("POST /chat = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+
+	// A failed turn leaves the history untouched
+	for _, c := range mem.conversations {
+		if c.UserID == user.ID && len(c.Messages) != 0 {
+			t.Errorf("failed turn stored %+v", c.Messages)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/chat", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	newHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /chat = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// inRepoRoot runs the rest of the test in the repository root, where the
+// templates are.
+func inRepoRoot(t *testing.T) {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+}
+
+// get requests path through the server's handler.
+func get(cookie *http.Cookie, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	newHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleIndex(t *testing.T) {
+	inRepoRoot(t)
+	mem, _ := newTestServer(t)
+	user := mem.addUser("ada@example.com")
+	cookie := login(t, user)
+	conv, _ := mem.CreateConversation(user.ID, "")
+	mem.SaveConversationMessages(user.ID, conv.ID, []llm.Message{
+		{Role: llm.RoleUser, Content: "old question"},
+		{Role: llm.RoleAssistant, Content: "old answer"},
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		fail   string
+		status int
+		want   string
+	}{
+		{"conversation", "/?c=" + conv.ID.Hex(), "", http.StatusOK, "old answer"},
+		{"untitled in the sidebar", "/", "", http.StatusOK, "New chat"},
+		{"unknown conversation", "/?c=" + primitive.NewObjectID().Hex(), "", http.StatusNotFound, "does not exist"},
+		{"malformed ID", "/?c=nope", "", http.StatusNotFound, "does not exist"},
+		{"load failure", "/?c=" + conv.ID.Hex(), "GetConversation", http.StatusInternalServerError, "could not be loaded"},
+		{"list failure", "/", "ListConversations", http.StatusInternalServerError, "conversations could not be loaded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start from the database rather than the server's cache
+			conversations = newConversationStore()
+			if tt.fail != "" {
+				mem.fail[tt.fail] = errStore
+				defer delete(mem.fail, tt.fail)
+			}
+			rec := get(cookie, tt.path)
+			if rec.Code != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("GET %s lacks %q", tt.path, tt.want)
+			}
+		})
+	}
+
+	if rec := get(nil, "/"); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("signed out GET / = %d to %q, want a redirect to the login", rec.Code, rec.Header().Get("Location"))
+	}
+}